@@ -0,0 +1,100 @@
+package rate
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// NewBucket returns a token-bucket limiter. Each task's bucket holds up to burst tokens
+// and refills continuously at rate tokens per second, so a task may briefly exceed the
+// steady-state rate by spending its saved tokens.
+//
+// Scheduling a slice consumes one token per second of the slice; Allow consumes a single
+// token. It panics if rate or burst is not positive.
+func NewBucket(rate float64, burst int) *bucket {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		panic(errors.New("rate: bucket rate must be positive and finite"))
+	}
+	if burst <= 0 {
+		panic(errors.New("rate: bucket burst must be positive"))
+	}
+	return &bucket{
+		rate:  rate,
+		burst: float64(burst),
+		clock: realClock{},
+		tasks: make(map[string]*tokens, preallocEntries),
+	}
+}
+
+// bucket is a token-bucket rate limiter
+type bucket struct {
+	rate, burst float64
+	clock       Clock
+
+	mu    sync.Mutex
+	tasks map[string]*tokens
+	swept time.Time
+}
+
+// tokens is the content of a task's bucket at a point in time
+type tokens struct {
+	n  float64
+	at time.Time
+}
+
+// Quantum returns the duration of the largest slice a full bucket can admit.
+func (b *bucket) Quantum() time.Duration {
+	return time.Duration(b.burst * float64(time.Second))
+}
+
+// Schedule consumes the slice's tokens from the task's bucket if they are available.
+// Otherwise it returns the delay until enough tokens accumulate, or Forever if the slice
+// needs more tokens than the bucket holds. See interface documentation.
+func (b *bucket) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	if slice > b.Quantum() {
+		return Forever
+	}
+	need := slice.Seconds()
+	now := b.clock.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+
+	t := b.tasks[task]
+	if t == nil {
+		t = &tokens{n: b.burst, at: now}
+		b.tasks[task] = t
+	}
+	t.n, t.at = b.fill(t, now), now
+	if t.n >= need {
+		t.n -= need
+		return 0
+	}
+	return time.Duration(math.Ceil((need - t.n) / b.rate * float64(time.Second)))
+}
+
+// Close releases the limiter's resources.
+func (b *bucket) Close() error {
+	return nil
+}
+
+// fill returns the number of tokens in t at time now
+func (b *bucket) fill(t *tokens, now time.Time) float64 {
+	return math.Min(b.burst, t.n+now.Sub(t.at).Seconds()*b.rate)
+}
+
+// sweep removes full buckets, at most once per tickInterval
+func (b *bucket) sweep(now time.Time) {
+	if now.Sub(b.swept) < tickInterval {
+		return
+	}
+	b.swept = now
+	for k, t := range b.tasks {
+		if b.fill(t, now) >= b.burst {
+			delete(b.tasks, k)
+		}
+	}
+}
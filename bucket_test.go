@@ -0,0 +1,66 @@
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBucketBurst(t *testing.T) {
+	clock := newFakeClock()
+	b := NewBucket(1, 5)
+	b.clock = clock
+	defer b.Close()
+	if b.Quantum() != time.Second*5 {
+		t.Fatalf("wrong quantum: want 5s, have %s", b.Quantum())
+	}
+	n := 0
+	for ; n < 100; n++ {
+		if !Allow(b, "a") {
+			break
+		}
+	}
+	if n != 5 {
+		t.Fatalf("bad burst count: want 5, have %d", n)
+	}
+	if delay := b.Schedule("a", time.Second*2); delay != time.Second*2 {
+		t.Fatalf("bad delay: want 2s, have %s", delay)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	clock := newFakeClock()
+	b := NewBucket(2, 2)
+	b.clock = clock
+	defer b.Close()
+	AllowSlice(b, "a", time.Second*2)
+	if Allow(b, "a") {
+		t.Fatalf("1/3: have allow, want deny")
+	}
+	clock.Advance(time.Second / 2)
+	if !Allow(b, "a") {
+		t.Fatalf("2/3: have deny, want allow")
+	}
+	clock.Advance(time.Hour)
+	if !AllowSlice(b, "a", time.Second*2) || Allow(b, "a") {
+		t.Fatalf("3/3: refill exceeded burst")
+	}
+}
+
+func TestBucketInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		rate  float64
+		burst int
+	}{
+		{0, 1}, {-1, 1}, {math.NaN(), 1}, {math.Inf(1), 1}, {1, 0}, {1, -1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("rate %v, burst %d: NewBucket did not panic", tc.rate, tc.burst)
+				}
+			}()
+			NewBucket(tc.rate, tc.burst)
+		}()
+	}
+}
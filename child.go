@@ -0,0 +1,48 @@
+package rate
+
+import (
+	"time"
+)
+
+// NewChild returns a Limiter with its own quantum that also charges parent, such as a
+// per-tenant limiter beneath a global one. A task is admitted only if both the child
+// and the parent admit it. The child is scheduled first; if the parent then denies
+// the task, the child's slice is given back and the parent's delay is returned.
+//
+// The child has only the methods of a Limiter, so every schedule goes through both.
+// Closing the child does not close the parent, so many children can share one parent.
+func NewChild(parent Limiter, quantum time.Duration) Limiter {
+	return &child{
+		own:    New(quantum),
+		parent: parent,
+	}
+}
+
+// child is a limiter that charges its parent after itself. Its own limiter is not
+// embedded, as its other methods would bypass the parent.
+type child struct {
+	own    *limiter
+	parent Limiter
+}
+
+// Quantum returns the smaller of the child and parent quantum.
+func (c *child) Quantum() time.Duration {
+	return min(c.own.Quantum(), c.parent.Quantum())
+}
+
+// Schedule schedules the task with the child and then the parent. See NewChild.
+func (c *child) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	r := c.own.Reserve(task, slice)
+	if !r.OK() {
+		return r.Delay()
+	}
+	if delay = c.parent.Schedule(task, slice); delay > 0 {
+		r.Cancel()
+	}
+	return delay
+}
+
+// Close closes the child. The parent is left open.
+func (c *child) Close() error {
+	return c.own.Close()
+}
@@ -0,0 +1,63 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChild(t *testing.T) {
+	clock := newFakeClock()
+	parent := NewWithClock(time.Second*3, clock)
+	defer parent.Close()
+	a := &child{own: NewWithClock(time.Second*2, clock), parent: parent}
+	b := &child{own: NewWithClock(time.Second*2, clock), parent: parent}
+	defer a.Close()
+	defer b.Close()
+	if a.Quantum() != time.Second*2 {
+		t.Fatalf("wrong quantum: want 2s, have %s", a.Quantum())
+	}
+	if !Allow(a, "x") || !Allow(a, "x") {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if Allow(a, "x") {
+		t.Fatalf("1/3: child quantum exceeded: have allow, want deny")
+	}
+	if !Allow(b, "x") {
+		t.Fatalf("2/3: have deny, want allow")
+	}
+	if delay := b.Schedule("x", time.Second); delay != time.Second {
+		t.Fatalf("2/3: parent should deny: want 1s, have %s", delay)
+	}
+	if r := b.own.Remaining("x"); r != time.Second {
+		t.Fatalf("3/3: child should give back the slice: want 1s remaining, have %s", r)
+	}
+}
+
+func TestNewChild(t *testing.T) {
+	parent := New(time.Second)
+	defer parent.Close()
+	c := NewChild(parent, time.Hour)
+	defer c.Close()
+	if c.Quantum() != time.Second {
+		t.Fatalf("wrong quantum: want 1s, have %s", c.Quantum())
+	}
+	if _, ok := c.(interface {
+		ScheduleErr(task string, slice time.Duration) (time.Duration, error)
+	}); ok {
+		t.Fatalf("child has ScheduleErr, which bypasses the parent")
+	}
+	if err := WaitFor(c, "a", time.Second, time.Second); err != nil {
+		t.Fatalf("1/2: %v", err)
+	}
+	if err := WaitFor(c, "a", time.Second, time.Millisecond*50); err != context.DeadlineExceeded {
+		t.Fatalf("2/2: parent should deny: want %v, have %v", context.DeadlineExceeded, err)
+	}
+	if r := parent.Remaining("a"); r > time.Second/2 {
+		t.Fatalf("parent was not charged: have %s remaining", r)
+	}
+	c.Close()
+	if !Allow(parent, "b") {
+		t.Fatalf("closing the child closed the parent")
+	}
+}
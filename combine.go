@@ -0,0 +1,142 @@
+package rate
+
+import (
+	"time"
+)
+
+// Combine returns a Limiter that admits a task only if every one of the limiters
+// admits it, such as a per-client limiter stacked on a global one.
+//
+// Every member is scheduled, and a denied task gets the largest of their delays, so that
+// waiting it out does not leave the task to be denied by another member. Members that
+// admitted a denied task are given the slice back if they support Reserve, as the
+// limiter returned by New does. Members that do not support it keep the slice consumed.
+func Combine(limiters ...Limiter) Limiter {
+	return combined(limiters)
+}
+
+// combined is an aggregate of multiple limiters
+type combined []Limiter
+
+// Quantum returns the smallest member quantum.
+func (c combined) Quantum() (q time.Duration) {
+	for i, l := range c {
+		if lq := l.Quantum(); i == 0 || lq < q {
+			q = lq
+		}
+	}
+	return q
+}
+
+// Schedule schedules the task with every member. See Combine.
+func (c combined) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	var undo []*Reservation
+	for _, l := range c {
+		delay = max(delay, reserve(l, task, slice, &undo))
+	}
+	if delay > 0 {
+		cancel(undo)
+	}
+	return delay
+}
+
+// Close closes every member, returning the first error.
+func (c combined) Close() (err error) {
+	for _, l := range c {
+		if e := l.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Member is a limiter in a WeightedCombine, charged Weight times each slice. A zero
+// Weight charges the slice unchanged.
+type Member struct {
+	// Name identifies the member when it denies a task
+	Name string
+
+	Limiter
+	Weight float64
+}
+
+// WeightedCombine is like Combine, but charges each member its weighted slice and can
+// report which member denied a task with ScheduleBinding.
+func WeightedCombine(members ...Member) *weighted {
+	return &weighted{members: members}
+}
+
+// weighted is an aggregate of weighted limiters
+type weighted struct {
+	members []Member
+}
+
+// Quantum returns the largest slice every member can admit.
+func (w *weighted) Quantum() (q time.Duration) {
+	for i, m := range w.members {
+		if mq := time.Duration(float64(m.Limiter.Quantum()) / m.weight()); i == 0 || mq < q {
+			q = mq
+		}
+	}
+	return q
+}
+
+// Schedule schedules the task with every member. See Combine.
+func (w *weighted) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	delay, _ = w.ScheduleBinding(task, slice)
+	return delay
+}
+
+// ScheduleBinding is like Schedule, but also returns the name of the member whose delay
+// was returned, or "" if the task was admitted.
+func (w *weighted) ScheduleBinding(task string, slice time.Duration) (delay time.Duration, binding string) {
+	var undo []*Reservation
+	for _, m := range w.members {
+		if d := reserve(m.Limiter, task, time.Duration(float64(slice)*m.weight()), &undo); d > delay {
+			delay, binding = d, m.Name
+		}
+	}
+	if delay > 0 {
+		cancel(undo)
+	}
+	return delay, binding
+}
+
+// Close closes every member, returning the first error.
+func (w *weighted) Close() (err error) {
+	for _, m := range w.members {
+		if e := m.Limiter.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// weight returns the member's weight, defaulting to 1
+func (m Member) weight() float64 {
+	if m.Weight == 0 {
+		return 1
+	}
+	return m.Weight
+}
+
+// reserve schedules the task with l, appending the reservation to undo if l supports
+// Reserve
+func reserve(l Limiter, task string, slice time.Duration, undo *[]*Reservation) time.Duration {
+	r, ok := l.(interface {
+		Reserve(task string, slice time.Duration) *Reservation
+	})
+	if !ok {
+		return l.Schedule(task, slice)
+	}
+	res := r.Reserve(task, slice)
+	*undo = append(*undo, res)
+	return res.Delay()
+}
+
+// cancel gives back every reservation in undo
+func cancel(undo []*Reservation) {
+	for _, r := range undo {
+		r.Cancel()
+	}
+}
@@ -0,0 +1,53 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCombine(t *testing.T) {
+	clock := newFakeClock()
+	a := NewWithClock(time.Second*3, clock)
+	b := NewWithClock(time.Second*2, clock)
+	l := Combine(a, b)
+	defer l.Close()
+	if l.Quantum() != time.Second*2 {
+		t.Fatalf("wrong quantum: want 2s, have %s", l.Quantum())
+	}
+	if !Allow(l, "a") || !Allow(l, "a") {
+		t.Fatalf("1/4: have deny, want allow")
+	}
+	if delay := l.Schedule("a", time.Second); delay != time.Second {
+		t.Fatalf("2/4: bad delay: want 1s, have %s", delay)
+	}
+	if r := a.Remaining("a"); r != time.Second {
+		t.Fatalf("3/4: admitting member should get the slice back: want 1s remaining, have %s", r)
+	}
+	AllowSlice(a, "b", time.Second*5/2)
+	AllowSlice(b, "b", time.Second*2)
+	if delay := l.Schedule("b", time.Second); delay != time.Second {
+		t.Fatalf("4/4: want the largest delay, 1s, have %s", delay)
+	}
+}
+
+func TestWeightedCombine(t *testing.T) {
+	clock := newFakeClock()
+	ip := NewWithClock(time.Second*2, clock)
+	global := NewWithClock(time.Second*3, clock)
+	l := WeightedCombine(Member{Name: "ip", Limiter: ip}, Member{Name: "global", Limiter: global, Weight: 0.5})
+	defer l.Close()
+	if l.Quantum() != time.Second*2 {
+		t.Fatalf("wrong quantum: want 2s, have %s", l.Quantum())
+	}
+	if !Allow(l, "a") || !Allow(l, "a") {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if _, binding := l.ScheduleBinding("a", time.Second); binding != "ip" {
+		t.Fatalf("2/3: bad binding member: want ip, have %q", binding)
+	}
+	clock.Advance(time.Second * 2)
+	AllowSlice(global, "a", time.Second*5/2)
+	if delay, binding := l.ScheduleBinding("a", time.Second*2); binding != "global" || delay != time.Second/2 {
+		t.Fatalf("3/3: want a 500ms delay from global, have %s from %q", delay, binding)
+	}
+}
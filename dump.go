@@ -0,0 +1,51 @@
+package rate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dumpEntries is the maximum number of tasks listed by String
+var dumpEntries = 10
+
+// String describes the limiter for debugging: its name, if it has one, its quantum,
+// the number of tasks it tracks, and up to ten of those tasks with the least remaining
+// quantum, the most throttled first. Tasks with equal remaining quantum are listed in order of their
+// formatted names, so the output of an unchanged limiter is stable.
+//
+//	quantum=30s tasks=12 example.com=0s example.org=12.5s ... (10 more)
+func (l *keyed[K]) String() string {
+	type entry struct {
+		task string
+		rem  time.Duration
+	}
+	var entries []entry
+	l.do(func() {
+		now := l.now()
+		entries = make([]entry, 0, len(l.marks))
+		for k, v := range l.marks {
+			entries = append(entries, entry{fmt.Sprint(k), l.remaining(v, now)})
+		}
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].rem != entries[j].rem {
+			return entries[i].rem < entries[j].rem
+		}
+		return entries[i].task < entries[j].task
+	})
+
+	var b strings.Builder
+	if l.name != "" {
+		fmt.Fprintf(&b, "name=%s ", l.name)
+	}
+	fmt.Fprintf(&b, "quantum=%s tasks=%d", l.Quantum(), len(entries))
+	for _, e := range entries[:min(dumpEntries, len(entries))] {
+		fmt.Fprintf(&b, " %s=%s", e.task, e.rem)
+	}
+	if n := len(entries) - dumpEntries; n > 0 {
+		fmt.Fprintf(&b, " ... (%d more)", n)
+	}
+	return b.String()
+}
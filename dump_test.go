@@ -0,0 +1,42 @@
+package rate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLimiterString(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*30, clock)
+	defer l.Close()
+	if have := l.String(); have != "quantum=30s tasks=0" {
+		t.Fatalf("bad empty dump: %q", have)
+	}
+	AllowSlice(l, "b", time.Second*30)
+	AllowSlice(l, "a", time.Second*30)
+	AllowSlice(l, "c", time.Second*10)
+	want := "quantum=30s tasks=3 a=0s b=0s c=20s"
+	if have := l.String(); have != want {
+		t.Fatalf("bad dump:\n\twant %q\n\thave %q", want, have)
+	}
+
+	for i := 0; i < dumpEntries; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	want = "quantum=30s tasks=13 a=0s b=0s c=20s 0=29s 1=29s 2=29s 3=29s 4=29s 5=29s 6=29s ... (3 more)"
+	if have := l.String(); have != want {
+		t.Fatalf("bad capped dump:\n\twant %q\n\thave %q", want, have)
+	}
+}
+
+func TestLimiterStringName(t *testing.T) {
+	l := NewWithOptions(time.Second*30, WithName("api"))
+	defer l.Close()
+	if have := l.String(); have != "name=api quantum=30s tasks=0" {
+		t.Fatalf("bad named dump: %q", have)
+	}
+	if s := l.Stats(); s.Name != "api" || l.Name() != "api" {
+		t.Fatalf("bad name: want api, have %q", s.Name)
+	}
+}
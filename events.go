@@ -0,0 +1,62 @@
+package rate
+
+import (
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventAdmit reports a task admitted by Schedule
+	EventAdmit EventKind = iota
+
+	// EventDeny reports a task denied by Schedule
+	EventDeny
+
+	// EventEvict reports a task removed from the limiter
+	EventEvict
+)
+
+// String returns the kind's name.
+func (k EventKind) String() string {
+	switch k {
+	case EventAdmit:
+		return "admit"
+	case EventDeny:
+		return "deny"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event reports something that happened to a task.
+type Event = KeyedEvent[string]
+
+// KeyedEvent is an Event for a task identified by a key of type K.
+type KeyedEvent[K comparable] struct {
+	Task K
+	Kind EventKind
+	At   time.Time
+}
+
+// Events returns the channel of events enabled by Options.Events. The limiter never
+// waits for the consumer: an event that does not fit in the channel's buffer is
+// dropped and counted in Stats.Dropped. The channel is closed once the limiter's
+// goroutine exits. Events returns nil if events are not enabled.
+func (l *keyed[K]) Events() <-chan KeyedEvent[K] {
+	return l.events
+}
+
+// emit sends an event without blocking, counting it as dropped if the buffer is full
+func (l *keyed[K]) emit(task K, kind EventKind, at time.Time) {
+	if l.events == nil {
+		return
+	}
+	select {
+	case l.events <- KeyedEvent[K]{Task: task, Kind: kind, At: at}:
+	default:
+		l.dropped.Add(1)
+	}
+}
@@ -0,0 +1,42 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterEvents(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: SweepAll, Events: 3})
+	Allow(l, "a")
+	Allow(l, "a")
+	clock.Advance(time.Second)
+	clock.Tick()
+	Allow(l, "b")
+	if s := l.Stats(); s.Dropped != 1 {
+		t.Fatalf("bad dropped count: want 1, have %d", s.Dropped)
+	}
+	l.Close()
+
+	want := []Event{
+		{Task: "a", Kind: EventAdmit, At: clock.now.Add(-time.Second)},
+		{Task: "a", Kind: EventDeny, At: clock.now.Add(-time.Second)},
+		{Task: "a", Kind: EventEvict, At: clock.now},
+	}
+	i := 0
+	for e := range l.Events() {
+		if i >= len(want) || e != want[i] {
+			t.Fatalf("bad event %d: %+v", i, e)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("bad event count: want %d, have %d", len(want), i)
+	}
+
+	l = New(time.Second)
+	defer l.Close()
+	if l.Events() != nil {
+		t.Fatalf("events enabled by default")
+	}
+}
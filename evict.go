@@ -0,0 +1,52 @@
+package rate
+
+import (
+	"sync"
+)
+
+// notifier calls a limiter's OnEvict function on its own goroutine, so the callback
+// never blocks the run goroutine and may call back into the limiter. Tasks are
+// delivered in the order they were evicted.
+type notifier[K comparable] struct {
+	fn   func(task K)
+	wake chan bool
+
+	mu    sync.Mutex
+	queue []K
+}
+
+func newNotifier[K comparable](fn func(task K)) *notifier[K] {
+	return &notifier[K]{
+		fn:   fn,
+		wake: make(chan bool, 1),
+	}
+}
+
+// add queues tasks for delivery without blocking
+func (n *notifier[K]) add(tasks []K) {
+	n.mu.Lock()
+	n.queue = append(n.queue, tasks...)
+	n.mu.Unlock()
+	select {
+	case n.wake <- true:
+	default:
+	}
+}
+
+// run delivers queued tasks until done is closed
+func (n *notifier[K]) run(done chan bool) {
+	for {
+		select {
+		case <-n.wake:
+		case <-done:
+			return
+		}
+		n.mu.Lock()
+		q := n.queue
+		n.queue = nil
+		n.mu.Unlock()
+		for _, task := range q {
+			n.fn(task)
+		}
+	}
+}
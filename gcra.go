@@ -0,0 +1,81 @@
+package rate
+
+import (
+	"sync"
+	"time"
+)
+
+// NewGCRA returns a limiter implementing the Generic Cell Rate Algorithm. Tasks
+// are paced to one cell per period, with up to burst cells admitted back to back.
+// Scheduling a slice costs one cell per second of the slice; Allow costs a single cell.
+//
+// Like the limiter returned by New, each task's state is a single timestamp: its
+// theoretical arrival time.
+func NewGCRA(period time.Duration, burst int) *gcra {
+	return &gcra{
+		period: period,
+		burst:  burst,
+		clock:  realClock{},
+		tats:   make(map[string]time.Time, preallocEntries),
+	}
+}
+
+// gcra is a GCRA rate limiter
+type gcra struct {
+	period time.Duration
+	burst  int
+	clock  Clock
+
+	mu    sync.Mutex
+	tats  map[string]time.Time
+	swept time.Time
+}
+
+// Quantum returns the duration of the largest slice admissible in one burst.
+func (g *gcra) Quantum() time.Duration {
+	return time.Duration(g.burst) * time.Second
+}
+
+// Schedule admits the slice's cells if the task conforms, otherwise it returns the
+// exact delay until it would, or Forever if the slice has more cells than a burst. See
+// interface documentation.
+func (g *gcra) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	if slice > g.Quantum() {
+		return Forever
+	}
+	now := g.clock.Now()
+	inc := time.Duration(slice.Seconds() * float64(g.period))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweep(now)
+
+	tat := g.tats[task]
+	if tat.Before(now) {
+		tat = now
+	}
+	tat = tat.Add(inc)
+	if delay = tat.Add(-g.period * time.Duration(g.burst)).Sub(now); delay > 0 {
+		return delay
+	}
+	g.tats[task] = tat
+	return delay
+}
+
+// Close releases the limiter's resources.
+func (g *gcra) Close() error {
+	return nil
+}
+
+// sweep removes tasks whose arrival time has passed, at most once per tickInterval
+func (g *gcra) sweep(now time.Time) {
+	if now.Sub(g.swept) < tickInterval {
+		return
+	}
+	g.swept = now
+	for k, tat := range g.tats {
+		if !tat.After(now) {
+			delete(g.tats, k)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRA(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGCRA(time.Second, 3)
+	g.clock = clock
+	defer g.Close()
+	n := 0
+	for ; n < 100; n++ {
+		if !Allow(g, "a") {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatalf("bad burst count: want 3, have %d", n)
+	}
+	if delay := g.Schedule("a", time.Second); delay != time.Second {
+		t.Fatalf("bad delay: want 1s, have %s", delay)
+	}
+	clock.Advance(time.Second / 2)
+	if delay := g.Schedule("a", time.Second); delay != time.Second/2 {
+		t.Fatalf("bad delay: want 500ms, have %s", delay)
+	}
+	clock.Advance(time.Second / 2)
+	if !Allow(g, "a") || Allow(g, "a") {
+		t.Fatalf("bad pacing: want exactly one cell per period")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/as/rate
+
+go 1.22
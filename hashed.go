@@ -0,0 +1,47 @@
+package rate
+
+import (
+	"time"
+)
+
+// NewHashed returns a limiter that hashes each task into one of a fixed number of
+// buckets and limits the buckets instead of the tasks, so it tracks at most buckets
+// entries however many distinct tasks it sees. The price is false sharing: tasks that
+// hash to the same bucket share one quantum, so a busy task can deny an idle one that
+// collides with it. Use enough buckets that collisions between busy tasks are rare, or
+// accept the limits as coarse protection.
+func NewHashed(quantum time.Duration, buckets int) *hashed {
+	if buckets < 1 {
+		buckets = 1
+	}
+	return &hashed{
+		l:       NewKeyed[uint32](quantum),
+		buckets: uint32(buckets),
+	}
+}
+
+// hashed is a limiter of hashed task buckets
+type hashed struct {
+	l       *keyed[uint32]
+	buckets uint32
+}
+
+// Buckets returns the number of buckets tasks are hashed into.
+func (h *hashed) Buckets() int {
+	return int(h.buckets)
+}
+
+// Quantum returns the quantum of each bucket.
+func (h *hashed) Quantum() time.Duration {
+	return h.l.Quantum()
+}
+
+// Schedule schedules the task's bucket. See interface documentation.
+func (h *hashed) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	return h.l.Schedule(hash(task)%h.buckets, slice)
+}
+
+// Close closes the limiter.
+func (h *hashed) Close() error {
+	return h.l.Close()
+}
@@ -0,0 +1,24 @@
+package rate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHashed(t *testing.T) {
+	l := NewHashed(time.Minute, 4)
+	defer l.Close()
+	if l.Buckets() != 4 {
+		t.Fatalf("bad bucket count: want 4, have %d", l.Buckets())
+	}
+	for i := 0; i < 1000; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	if n := l.l.Len(); n != 4 {
+		t.Fatalf("bad tracked count: want 4, have %d", n)
+	}
+	if AllowSlice(l, "0", time.Minute) {
+		t.Fatalf("shared bucket: have allow, want deny")
+	}
+}
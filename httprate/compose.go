@@ -0,0 +1,22 @@
+package httprate
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Compose returns a TaskFunc that names a request by joining the names returned by
+// funcs with sep, such as the client IP, API key, and route. An empty name is written
+// as "-", so every task name has one part per func and a missing part cannot shift
+// the others. Choose a sep that cannot occur in the parts.
+func Compose(sep string, funcs ...func(*http.Request) string) func(*http.Request) string {
+	return func(rx *http.Request) string {
+		parts := make([]string, len(funcs))
+		for i, fn := range funcs {
+			if parts[i] = fn(rx); parts[i] == "" {
+				parts[i] = "-"
+			}
+		}
+		return strings.Join(parts, sep)
+	}
+}
@@ -0,0 +1,27 @@
+package httprate
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ByHeader returns a TaskFunc that names a request by the value of the named header,
+// such as X-API-Key or a tenant header, falling back to the client's IP address in
+// RemoteAddr for requests without it. See ByHeaderOr.
+func ByHeader(name string) func(*http.Request) string {
+	return ByHeaderOr(name, RemoteIP(nil))
+}
+
+// ByHeaderOr is like ByHeader, but names requests without the header, or with an empty
+// one, by fallback instead, so they are limited by their own tasks rather than sharing
+// the empty one. Apart from surrounding space, the value is used as is, so a bearer
+// token in Authorization becomes the task name and is shown wherever task names are,
+// such as in Logger and OnDecision.
+func ByHeaderOr(name string, fallback func(*http.Request) string) func(*http.Request) string {
+	return func(rx *http.Request) string {
+		if v := strings.TrimSpace(rx.Header.Get(name)); v != "" {
+			return v
+		}
+		return fallback(rx)
+	}
+}
@@ -2,8 +2,14 @@
 package httprate
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/as/rate"
@@ -20,31 +26,200 @@ var DefaultConfig = Config{
 // task name associated with an http.Request. It then schedules that named task with
 // the configured Limiter and Cost, running the underlying handler if and only if that
 // task can be executed at time.Now().
+//
+// Its fields must not be set while it is serving requests. The setters SetCost,
+// SetLimiter, SetHandler, SetErrorHandler, and Reconfigure are safe to call at any time
+// instead.
 type LimitedHandler struct {
 	// Cost is the unit of duration for running the underlying handler. The Cost does not
 	// have to correspond to real-world execution time.
 	Cost time.Duration
 
-	// Limiter this handler will use to decide whether it can run its underlying handler
+	// Limiter this handler will use to decide whether it can run its underlying handler.
+	// Its methods promoted to the LimitedHandler ignore SetLimiter.
 	rate.Limiter
 
 	// Config has optional settings
 	Config
 
-	// Handler is the underlying handler that will be run if the request is allowed
+	// Limits, if set, are enforced along with the Limiter. See HandlerAll.
+	Limits []Limit
+
+	// Handler is the underlying handler that will be run if the request is allowed. It must
+	// not be set while the LimitedHandler is serving requests; use SetHandler instead.
 	Handler http.Handler
+
+	// handler and errorHandler, once set by SetHandler and SetErrorHandler, replace
+	// Handler and Error
+	handler, errorHandler atomic.Pointer[http.Handler]
+
+	// baseCost and lim, once set by SetCost and SetLimiter, replace Cost and Limiter
+	baseCost atomic.Pointer[time.Duration]
+	lim      atomic.Pointer[rate.Limiter]
+
+	// reconf is held for reading while a request is scheduled, and for writing by
+	// Reconfigure
+	reconf sync.RWMutex
+}
+
+// ErrNotReconfigurable is returned by Reconfigure for a limiter without a SetQuantum method.
+var ErrNotReconfigurable = errors.New("httprate: limiter cannot set its quantum")
+
+// SetCost replaces the Cost, and is safe to call while the LimitedHandler is serving
+// requests, such as to retune a limit live. A CostFunc still overrides it.
+func (l *LimitedHandler) SetCost(cost time.Duration) {
+	l.baseCost.Store(&cost)
+}
+
+// SetLimiter replaces the Limiter, and is safe to call while the LimitedHandler is
+// serving requests. The old limiter is not closed, and requests already admitted by
+// it keep their admission.
+func (l *LimitedHandler) SetLimiter(lim rate.Limiter) {
+	l.lim.Store(&lim)
+}
+
+// Reconfigure sets the limiter's quantum and the Cost together, so that no request is
+// scheduled with the new cost against the old quantum, or the old cost against the new
+// one, as can happen between separate calls to SetQuantum and SetCost during a live
+// reload. The limiter must have a SetQuantum method, as the limiter returned by rate.New
+// does, or nothing is changed and ErrNotReconfigurable is returned. Nothing is changed
+// either if quantum is not positive, and rate.ErrInvalidQuantum is returned. A CostFunc
+// still overrides the cost.
+func (l *LimitedHandler) Reconfigure(quantum, cost time.Duration) error {
+	if quantum <= 0 {
+		return rate.ErrInvalidQuantum
+	}
+	l.reconf.Lock()
+	defer l.reconf.Unlock()
+	s, ok := l.limiter().(interface{ SetQuantum(time.Duration) })
+	if !ok {
+		return ErrNotReconfigurable
+	}
+	s.SetQuantum(quantum)
+	l.SetCost(cost)
+	return nil
+}
+
+// limiter returns the limiter
+func (l *LimitedHandler) limiter() rate.Limiter {
+	if lim := l.lim.Load(); lim != nil {
+		return *lim
+	}
+	return l.Limiter
+}
+
+// SetHandler replaces the underlying handler. Unlike setting Handler, it is safe to call
+// while the LimitedHandler is serving requests, such as to switch handlers on a feature
+// flag. Requests already running keep the handler they started with.
+func (l *LimitedHandler) SetHandler(h http.Handler) {
+	l.handler.Store(&h)
+}
+
+// SetErrorHandler replaces the Config's Error handler, and is safe to call while the
+// LimitedHandler is serving requests. A nil h selects the default error handler.
+func (l *LimitedHandler) SetErrorHandler(h http.Handler) {
+	if h == nil {
+		h = l.Config.defaultError()
+	}
+	l.errorHandler.Store(&h)
+}
+
+// next returns the underlying handler
+func (l *LimitedHandler) next() http.Handler {
+	if h := l.handler.Load(); h != nil {
+		return *h
+	}
+	return l.Handler
+}
+
+// onError returns the error handler
+func (l *LimitedHandler) onError() http.Handler {
+	if h := l.errorHandler.Load(); h != nil {
+		return *h
+	}
+	return l.Error
 }
 
 // Config configures a LimitedHandler with supplementay options
 type Config struct {
+	// Skip, if set, exempts a request from rate limiting when it returns true, such as a
+	// health check. Skipped requests run the underlying handler without consulting the
+	// limiter or TaskFunc.
+	Skip func(*http.Request) bool
+
 	// TaskFunc extracts a task name from an http request/response pair. The default is the request host.
 	TaskFunc func(*http.Request) string
 
+	// LateTaskFunc, if set, names requests for which TaskFunc returns "", such as those keyed by
+	// an API key that must be validated first. It still runs before the underlying handler.
+	LateTaskFunc func(*http.Request) string
+
+	// CostFunc, if set, returns the cost of running the underlying handler for a request,
+	// overriding the handler's fixed Cost. For example, a cost proportional to the request's
+	// Content-Length limits bandwidth instead of request count.
+	CostFunc func(*http.Request) time.Duration
+
+	// ChargeOn, if set, decides from the status code the underlying handler wrote whether an
+	// admitted request is charged, such as only for 2xx responses so that client errors do
+	// not count against the task. The cost is still scheduled before the handler runs and is
+	// given back afterwards if ChargeOn returns false, so limiters must support Reserve, as
+	// the limiter returned by rate.New does. Limiters that do not support it keep the cost.
+	ChargeOn func(statusCode int) bool
+
 	// Error handler, if set, is called when a rate limit is hit instead of the default handler, which
-	// returns a 429 status and writes "rate limit exceeded" to the http.ResponseWriter
+	// returns a 429 status and writes "rate limit exceeded" to the http.ResponseWriter. The Retry-After
+	// header is set before it is called, unless the delay is rate.Forever because the request can
+	// never be admitted, and RetryAfter and DelayFor return the delay from its request and response
+	// writer.
 	Error http.Handler
+
+	// OnLimit, if set, is called with each denied request, its task name, and the delay returned
+	// by the limiter before the Error handler writes the response. It is never called for admitted
+	// requests.
+	OnLimit func(rx *http.Request, task string, delay time.Duration)
+
+	// Logger, if set, logs each decision with the task, decision, delay, and remote address.
+	// Admitted requests are logged at slog.LevelDebug and denied ones at DenyLevel, which
+	// defaults to slog.LevelInfo.
+	Logger    *slog.Logger
+	DenyLevel slog.Level
+
+	// OnDecision, if set, is called with every request decided by the limiter, its task name,
+	// and the delay returned, which is not positive for admitted requests, such as to record
+	// metrics. Package rateotel records them with OpenTelemetry. Skipped requests are not
+	// decided.
+	OnDecision func(rx *http.Request, task string, delay time.Duration)
+
+	// Status, Body, and ContentType configure the default error handler when Error is not set.
+	// The default status is http.StatusTooManyRequests and the default body is its status text.
+	Status      int
+	Body        []byte
+	ContentType string
+
+	// Headers selects the rate-limit headers written with every response. The default,
+	// NoHeaders, writes none, so limits are not disclosed to clients.
+	Headers HeaderStyle
 }
 
+// HeaderStyle names a set of rate-limit response headers. The limit is the limiter's
+// quantum, the remaining value is the task's remaining quantum, and the reset value is
+// the delay before the task's quantum is fully replenished, all in seconds. Remaining
+// and reset are only written if the limiter has a ScheduleInfo or Remaining method, as
+// the limiter returned by rate.New does.
+type HeaderStyle int
+
+const (
+	// NoHeaders writes no rate-limit headers
+	NoHeaders HeaderStyle = iota
+
+	// XHeaders writes X-RateLimit-Limit, X-RateLimit-Remaining, and X-RateLimit-Reset
+	XHeaders
+
+	// IETFHeaders writes RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset, as
+	// named by the IETF httpapi RateLimit header fields draft
+	IETFHeaders
+)
+
 func (c *Config) ensure() *Config {
 	if c == nil {
 		d := DefaultConfig
@@ -54,11 +229,19 @@ func (c *Config) ensure() *Config {
 		c.TaskFunc = host
 	}
 	if c.Error == nil {
-		c.Error = http.HandlerFunc(LimitExceeded)
+		c.Error = c.defaultError()
 	}
 	return c
 }
 
+// defaultError returns the error handler used when Error is not set
+func (c *Config) defaultError() http.Handler {
+	if c.Status != 0 || c.Body != nil || c.ContentType != "" {
+		return limitExceeded(c.Status, c.Body, c.ContentType)
+	}
+	return http.HandlerFunc(LimitExceeded)
+}
+
 // Handler returns an http.Handler that checks the incoming request against the limiter and cost and executes handler
 // if the limiter allows it at time.Now(). A nil conf is silently replaced with the default configuration.
 func Handler(lim rate.Limiter, cost time.Duration, conf *Config, handler http.Handler) *LimitedHandler {
@@ -75,13 +258,218 @@ func HandlerFunc(lim rate.Limiter, cost time.Duration, conf *Config, h func(http
 	return Handler(lim, cost, conf, http.HandlerFunc(h))
 }
 
+// Middleware returns a function that wraps a handler in a LimitedHandler, for use in
+// middleware chains. A nil conf is silently replaced with the default configuration.
+func Middleware(lim rate.Limiter, cost time.Duration, conf *Config) func(http.Handler) http.Handler {
+	c := *conf.ensure()
+	return func(handler http.Handler) http.Handler {
+		return Handler(lim, cost, &c, handler)
+	}
+}
+
 // ServeHTTP implements http.Handler
 func (l *LimitedHandler) ServeHTTP(tx http.ResponseWriter, rx *http.Request) {
-	if !rate.AllowSlice(l.Limiter, l.TaskFunc(rx), l.Cost) {
-		l.Error.ServeHTTP(tx, rx)
+	next := l.next()
+	if l.Skip != nil && l.Skip(rx) {
+		next.ServeHTTP(tx, rx)
+		return
+	}
+	task := l.TaskFunc(rx)
+	if task == "" && l.LateTaskFunc != nil {
+		task = l.LateTaskFunc(rx)
+	}
+	l.reconf.RLock()
+	lim, cost := l.limiter(), l.cost(rx)
+	d := l.schedule(lim, rx, task, cost)
+	l.reconf.RUnlock()
+	delay := d.delay
+	l.writeHeaders(lim, tx.Header(), d)
+	l.log(rx, task, delay)
+	if l.OnDecision != nil {
+		l.OnDecision(rx, task, delay)
+	}
+	if delay > 0 {
+		if l.OnLimit != nil {
+			l.OnLimit(rx, task, delay)
+		}
+		if delay != rate.Forever {
+			tx.Header().Set("Retry-After", strconv.FormatInt(seconds(delay), 10))
+		}
+		l.onError().ServeHTTP(&delayWriter{tx, delay}, rx.WithContext(context.WithValue(rx.Context(), delayKey{}, delay)))
+		return
+	}
+	a := &Admission{Task: task, Cost: cost, Remaining: d.remaining}
+	rx = rx.WithContext(context.WithValue(rx.Context(), admissionKey{}, a))
+	if l.ChargeOn == nil {
+		next.ServeHTTP(tx, rx)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: tx}
+	next.ServeHTTP(sw, rx)
+	if !l.ChargeOn(sw.status()) {
+		cancel(d.undo)
+	}
+}
+
+// statusWriter records the status code written by the underlying handler
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+// WriteHeader records the first status code written
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records an implicit http.StatusOK if no status code was written
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying response writer, for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the status code of the response, http.StatusOK if none was written
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// Admission describes an admitted request to the handler it was admitted to.
+type Admission struct {
+	// Task and Cost are the task name and cost scheduled for the request
+	Task string
+	Cost time.Duration
+
+	// Remaining is the task's quantum remaining after the request was admitted, or zero
+	// if the limiter has neither a ScheduleInfo nor a Remaining method
+	Remaining time.Duration
+}
+
+// admissionKey is the context key of an Admission
+type admissionKey struct{}
+
+// FromContext returns the Admission attached to the context of a request admitted by a
+// LimitedHandler, or false if there is none.
+func FromContext(ctx context.Context) (*Admission, bool) {
+	a, ok := ctx.Value(admissionKey{}).(*Admission)
+	return a, ok
+}
+
+// log logs the decision for the request, if there is a logger
+func (l *LimitedHandler) log(rx *http.Request, task string, delay time.Duration) {
+	if l.Logger == nil {
 		return
 	}
-	l.Handler.ServeHTTP(tx, rx)
+	level, decision := slog.LevelDebug, "admit"
+	if delay > 0 {
+		level, decision = l.DenyLevel, "deny"
+	}
+	l.Logger.LogAttrs(rx.Context(), level, "rate limit decision",
+		slog.String("task", task),
+		slog.String("decision", decision),
+		slog.Duration("delay", max(delay, 0)),
+		slog.String("remote_addr", rx.RemoteAddr),
+	)
+}
+
+// cost returns the cost of serving the request
+func (l *LimitedHandler) cost(rx *http.Request) time.Duration {
+	if l.CostFunc != nil {
+		return l.CostFunc(rx)
+	}
+	if cost := l.baseCost.Load(); cost != nil {
+		return *cost
+	}
+	return l.Cost
+}
+
+// writeHeaders writes the configured rate-limit headers for the decision
+func (l *LimitedHandler) writeHeaders(lim rate.Limiter, h http.Header, d decision) {
+	var prefix string
+	switch l.Headers {
+	case XHeaders:
+		prefix = "X-RateLimit-"
+	case IETFHeaders:
+		prefix = "RateLimit-"
+	default:
+		return
+	}
+	q := lim.Quantum()
+	h.Set(prefix+"Limit", strconv.FormatInt(int64(q/time.Second), 10))
+	if d.known {
+		h.Set(prefix+"Remaining", strconv.FormatInt(int64(d.remaining/time.Second), 10))
+		h.Set(prefix+"Reset", strconv.FormatInt(seconds(q-d.remaining), 10))
+	}
+}
+
+// remaining returns the task's remaining quantum, or false if the limiter cannot report it
+func remaining(lim rate.Limiter, task string) (time.Duration, bool) {
+	r, ok := lim.(interface {
+		Remaining(task string) time.Duration
+	})
+	if !ok {
+		return 0, false
+	}
+	return r.Remaining(task), true
+}
+
+// delayKey is the context key of the delay passed to the error handler
+type delayKey struct{}
+
+// RetryAfter returns the delay before a request passed to a Config's Error handler
+// may be retried, or zero for any other request.
+func RetryAfter(rx *http.Request) time.Duration {
+	delay, _ := rx.Context().Value(delayKey{}).(time.Duration)
+	return delay
+}
+
+// delayWriter is the response writer passed to the error handler
+type delayWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+// Unwrap returns the underlying response writer, for http.ResponseController
+func (w *delayWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DelayFor returns the delay before a request may be retried from the response writer passed to
+// a Config's Error handler, or zero for any other response writer. The writer, and so the delay,
+// is only valid until the Error handler returns. Writers wrapping it are searched through their
+// Unwrap methods, as with http.ResponseController.
+func DelayFor(tx http.ResponseWriter) time.Duration {
+	for {
+		switch w := tx.(type) {
+		case *delayWriter:
+			return w.delay
+		case interface{ Unwrap() http.ResponseWriter }:
+			tx = w.Unwrap()
+		default:
+			return 0
+		}
+	}
+}
+
+// seconds returns the delay in whole seconds, rounded up
+func seconds(delay time.Duration) int64 {
+	s := int64(delay / time.Second)
+	if delay%time.Second != 0 {
+		s++
+	}
+	return s
 }
 
 // LimitExceeded is the default error handler. It writes the http.StatusTooManyRequests message along with
@@ -91,7 +479,45 @@ func LimitExceeded(tx http.ResponseWriter, rx *http.Request) {
 	tx.Write([]byte(http.StatusText(http.StatusTooManyRequests)))
 }
 
+// JSONError is an error handler that writes the http.StatusTooManyRequests status with a JSON body
+// holding the number of seconds before the request may be retried, for use as a Config's Error:
+//
+//	{"error":"rate limit exceeded","retry_after":2}
+//
+// The retry_after field is omitted if the request can never be admitted.
+var JSONError http.Handler = http.HandlerFunc(jsonError)
+
+func jsonError(tx http.ResponseWriter, rx *http.Request) {
+	body := struct {
+		Error      string `json:"error"`
+		RetryAfter int64  `json:"retry_after,omitempty"`
+	}{Error: "rate limit exceeded"}
+	if delay := RetryAfter(rx); delay != rate.Forever {
+		body.RetryAfter = seconds(delay)
+	}
+	tx.Header().Set("Content-Type", "application/json")
+	tx.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(tx).Encode(body)
+}
+
+// limitExceeded returns an error handler like LimitExceeded that writes the given status,
+// body, and content type instead
+func limitExceeded(status int, body []byte, contentType string) http.HandlerFunc {
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	if body == nil {
+		body = []byte(http.StatusText(status))
+	}
+	return func(tx http.ResponseWriter, rx *http.Request) {
+		if contentType != "" {
+			tx.Header().Set("Content-Type", contentType)
+		}
+		tx.WriteHeader(status)
+		tx.Write(body)
+	}
+}
+
 func host(rx *http.Request) string {
-	log.Println(rx.Host)
 	return rx.Host
 }
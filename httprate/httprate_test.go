@@ -0,0 +1,696 @@
+package httprate
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/as/rate"
+)
+
+func TestSetHandler(t *testing.T) {
+	status := func(code int) http.Handler {
+		return http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+			tx.WriteHeader(code)
+		})
+	}
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	l := Handler(lim, time.Millisecond, nil, status(http.StatusOK))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tx := httptest.NewRecorder()
+				l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+				if tx.Code != http.StatusOK && tx.Code != http.StatusAccepted {
+					t.Errorf("bad status: %d", tx.Code)
+				}
+			}
+		}()
+	}
+	handlers := []http.Handler{status(http.StatusOK), status(http.StatusAccepted)}
+	for i := 0; i < 100; i++ {
+		l.SetHandler(handlers[i%2])
+		l.SetErrorHandler(status(http.StatusServiceUnavailable))
+	}
+	wg.Wait()
+
+	l.SetHandler(status(http.StatusAccepted))
+	tx := httptest.NewRecorder()
+	l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+	if tx.Code != http.StatusAccepted {
+		t.Fatalf("handler not replaced: want %d, have %d", http.StatusAccepted, tx.Code)
+	}
+}
+
+func TestSetErrorHandler(t *testing.T) {
+	l := Handler(rate.Denied(time.Second), time.Second, nil, http.NotFoundHandler())
+	for _, tc := range []struct {
+		h    http.Handler
+		want int
+	}{
+		{http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+			tx.WriteHeader(http.StatusServiceUnavailable)
+		}), http.StatusServiceUnavailable},
+		{nil, http.StatusTooManyRequests},
+	} {
+		l.SetErrorHandler(tc.h)
+		tx := httptest.NewRecorder()
+		l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+		if tx.Code != tc.want {
+			t.Errorf("bad status: want %d, have %d", tc.want, tx.Code)
+		}
+	}
+}
+
+func TestSetCostLimiter(t *testing.T) {
+	a, b := rate.New(time.Minute), rate.New(time.Minute)
+	defer a.Close()
+	defer b.Close()
+	l := Handler(a, time.Millisecond, nil, http.NotFoundHandler())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		l.SetCost(time.Millisecond * time.Duration(1+i%2))
+		l.SetLimiter([]rate.Limiter{a, b}[i%2])
+	}
+	wg.Wait()
+
+	c := rate.New(time.Minute)
+	defer c.Close()
+	l.SetLimiter(c)
+	l.SetCost(time.Minute)
+	rx := httptest.NewRequest("GET", "/", nil)
+	l.ServeHTTP(httptest.NewRecorder(), rx)
+	if r := c.Remaining(rx.Host); r > time.Second {
+		t.Fatalf("bad remaining after SetCost and SetLimiter: want about 0s, have %s", r)
+	}
+}
+
+func TestOnDecision(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	var delays []time.Duration
+	conf := &Config{
+		Skip: func(rx *http.Request) bool { return rx.URL.Path == "/health" },
+		OnDecision: func(rx *http.Request, task string, delay time.Duration) {
+			if task != rx.Host {
+				t.Errorf("bad task: want %q, have %q", rx.Host, task)
+			}
+			delays = append(delays, delay)
+		},
+	}
+	l := Handler(lim, time.Minute, conf, http.NotFoundHandler())
+	for _, path := range []string{"/", "/health", "/"} {
+		l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if len(delays) != 2 || delays[0] > 0 || delays[1] <= 0 {
+		t.Fatalf("bad decisions: want one admit and one deny, have delays %v", delays)
+	}
+}
+
+// pairLimiter is a limiter that reports a schedule whose slice does not belong with
+// the quantum it is scheduled against
+type pairLimiter struct {
+	quantum atomic.Int64
+	pairs   map[time.Duration]time.Duration
+	bad     atomic.Int64
+}
+
+func (p *pairLimiter) Quantum() time.Duration { return time.Duration(p.quantum.Load()) }
+func (p *pairLimiter) Close() error           { return nil }
+
+// SetQuantum sets the quantum and then takes a while to return, as the limiter returned
+// by rate.New does while it waits for its goroutine
+func (p *pairLimiter) SetQuantum(quantum time.Duration) {
+	p.quantum.Store(int64(quantum))
+	time.Sleep(time.Microsecond * 50)
+}
+
+func (p *pairLimiter) Schedule(task string, slice time.Duration) time.Duration {
+	if p.pairs[p.Quantum()] != slice {
+		p.bad.Add(1)
+	}
+	return 0
+}
+
+func TestReconfigure(t *testing.T) {
+	p := &pairLimiter{pairs: map[time.Duration]time.Duration{
+		time.Second: time.Millisecond,
+		time.Minute: time.Second,
+	}}
+	p.SetQuantum(time.Second)
+	l := Handler(p, time.Millisecond, nil, http.NotFoundHandler())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		q := []time.Duration{time.Minute, time.Second}[i%2]
+		if err := l.Reconfigure(q, p.pairs[q]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if n := p.bad.Load(); n != 0 {
+		t.Fatalf("%d requests saw a mismatched quantum and cost", n)
+	}
+
+	if err := Handler(rate.Unlimited(), time.Second, nil, http.NotFoundHandler()).Reconfigure(time.Second, time.Second); err != ErrNotReconfigurable {
+		t.Fatalf("bad error for a limiter without SetQuantum: want %v, have %v", ErrNotReconfigurable, err)
+	}
+
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	h := Handler(lim, time.Second, nil, http.NotFoundHandler())
+	if err := h.Reconfigure(0, time.Minute); err != rate.ErrInvalidQuantum {
+		t.Fatalf("bad error for a zero quantum: want %v, have %v", rate.ErrInvalidQuantum, err)
+	}
+	if q, c := lim.Quantum(), h.cost(httptest.NewRequest("GET", "/", nil)); q != time.Second || c != time.Second {
+		t.Fatalf("invalid reconfiguration was applied: have quantum %s and cost %s", q, c)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	_, proxies6, _ := net.ParseCIDR("fd00::/8")
+	trusted := RemoteIP([]net.IPNet{*proxies, *proxies6})
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"ipv4 port", "192.0.2.1:1234", nil, "", "192.0.2.1"},
+		{"ipv6 port", "[2001:db8::1]:1234", nil, "", "2001:db8::1"},
+		{"ipv6 no port", "2001:db8::1", nil, "", "2001:db8::1"},
+		{"bad remote", "pipe", nil, "", "pipe"},
+		{"untrusted xff", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.2", "192.0.2.1"},
+		{"trusted xff", "10.0.0.1:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"xff list", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1,10.0.0.2"}, "", "198.51.100.1"},
+		{"xff headers", "10.0.0.1:1234", []string{"198.51.100.1", "10.0.0.2"}, "", "198.51.100.1"},
+		{"xff ipv6", "[fd00::1]:1234", []string{"2001:db8::2, fd00::2"}, "", "2001:db8::2"},
+		{"xff all trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"xff bad hop", "10.0.0.1:1234", []string{"198.51.100.1, junk, 10.0.0.2"}, "198.51.100.2", "198.51.100.2"},
+		{"xff bad hop no real ip", "10.0.0.1:1234", []string{"junk, 10.0.0.2"}, "", "10.0.0.1"},
+		{"real ip", "10.0.0.1:1234", nil, "198.51.100.2", "198.51.100.2"},
+	} {
+		rx := httptest.NewRequest("GET", "/", nil)
+		rx.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			rx.Header.Add("X-Forwarded-For", v)
+		}
+		if tc.realIP != "" {
+			rx.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if have := trusted(rx); have != tc.want {
+			t.Errorf("%s: want %q, have %q", tc.name, tc.want, have)
+		}
+	}
+
+	rx := httptest.NewRequest("GET", "/", nil)
+	rx.RemoteAddr = "10.0.0.1:1234"
+	rx.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if have := RemoteIP(nil)(rx); have != "10.0.0.1" {
+		t.Errorf("no trusted proxies: want %q, have %q", "10.0.0.1", have)
+	}
+}
+
+func TestRetryAfterForever(t *testing.T) {
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	l := Handler(lim, time.Second*2, &Config{Error: JSONError}, http.NotFoundHandler())
+	tx := httptest.NewRecorder()
+	l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+	if tx.Code != http.StatusTooManyRequests {
+		t.Fatalf("bad status: want %d, have %d", http.StatusTooManyRequests, tx.Code)
+	}
+	if v, ok := tx.Result().Header["Retry-After"]; ok {
+		t.Fatalf("Retry-After set for a request that can never be admitted: %q", v)
+	}
+	if body := strings.TrimSpace(tx.Body.String()); body != `{"error":"rate limit exceeded"}` {
+		t.Fatalf("bad body: %s", body)
+	}
+}
+
+func TestMux(t *testing.T) {
+	echo := http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		tx.Write([]byte(rx.URL.Path))
+	})
+	h := Mux(map[string]RouteLimit{
+		"/api/":    {Limiter: rate.Denied(time.Second), Cost: time.Second},
+		"/a/b":     {Limiter: rate.Unlimited(), Cost: time.Second},
+		"GET /c/d": {Limiter: rate.Denied(time.Second), Cost: time.Second},
+	})(echo)
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/api/x", http.StatusTooManyRequests},
+		{"GET", "/api", http.StatusTooManyRequests},
+		{"GET", "/a/b", http.StatusOK},
+		{"GET", "/a//b", http.StatusOK},
+		{"GET", "/c/d", http.StatusTooManyRequests},
+		{"POST", "/c/d", http.StatusOK},
+		{"GET", "/other", http.StatusOK},
+	} {
+		tx := httptest.NewRecorder()
+		rx := httptest.NewRequest(tc.method, "/", nil)
+		rx.URL.Path = tc.path
+		h.ServeHTTP(tx, rx)
+		if tx.Code != tc.want {
+			t.Errorf("%s %s: bad status: want %d, have %d", tc.method, tc.path, tc.want, tx.Code)
+		}
+		if tc.want == http.StatusOK && tx.Body.String() != tc.path {
+			t.Errorf("%s %s: handler saw path %q", tc.method, tc.path, tx.Body.String())
+		}
+	}
+}
+
+// infoLimiter is a limiter with ScheduleInfo that counts its calls
+type infoLimiter struct {
+	rate.Limiter
+	info, remaining atomic.Int64
+}
+
+func (i *infoLimiter) ScheduleInfo(task string, slice time.Duration) rate.Info {
+	i.info.Add(1)
+	return rate.Info{Remaining: time.Second * 7}
+}
+
+func (i *infoLimiter) Remaining(task string) time.Duration {
+	i.remaining.Add(1)
+	return 0
+}
+
+func TestScheduleInfo(t *testing.T) {
+	lim := &infoLimiter{Limiter: rate.Unlimited()}
+	var a *Admission
+	l := Handler(lim, time.Second, &Config{Headers: XHeaders}, http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		a, _ = FromContext(rx.Context())
+	}))
+	tx := httptest.NewRecorder()
+	l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+	if n, m := lim.info.Load(), lim.remaining.Load(); n != 1 || m != 0 {
+		t.Fatalf("bad round trips: want 1 ScheduleInfo and 0 Remaining, have %d and %d", n, m)
+	}
+	if v := tx.Header().Get("X-RateLimit-Remaining"); v != "7" {
+		t.Fatalf("bad remaining header: want 7, have %q", v)
+	}
+	if a == nil || a.Remaining != time.Second*7 {
+		t.Fatalf("bad admission: %+v", a)
+	}
+}
+
+func TestHandlerAll(t *testing.T) {
+	client, global := rate.New(time.Second*2), rate.New(time.Second*3)
+	defer client.Close()
+	defer global.Close()
+	l := HandlerAll([]Limit{
+		{Limiter: client, Cost: time.Second},
+		{Limiter: global, Cost: time.Second, TaskFunc: func(*http.Request) string { return "all" }},
+		{Limiter: rate.Unlimited(), Cost: time.Second},
+	}, nil, http.NotFoundHandler())
+	serve := func(host string) int {
+		tx := httptest.NewRecorder()
+		rx := httptest.NewRequest("GET", "/", nil)
+		rx.Host = host
+		l.ServeHTTP(tx, rx)
+		return tx.Code
+	}
+	if serve("a") != http.StatusNotFound || serve("a") != http.StatusNotFound {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if serve("a") != http.StatusTooManyRequests {
+		t.Fatalf("1/3: client limit exceeded: have allow, want deny")
+	}
+	if r := global.Remaining("all"); r < time.Second/2 {
+		t.Fatalf("2/3: global limit charged for a denied request: have %s remaining", r)
+	}
+	if serve("b") != http.StatusNotFound {
+		t.Fatalf("2/3: have deny, want allow")
+	}
+	if serve("c") != http.StatusTooManyRequests {
+		t.Fatalf("3/3: global limit exceeded: have allow, want deny")
+	}
+	if r := client.Remaining("c"); r < time.Second*2-time.Second/2 {
+		t.Fatalf("3/3: client limit not given back its cost: have %s remaining", r)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("HandlerAll did not panic without limits")
+		}
+	}()
+	HandlerAll(nil, nil, http.NotFoundHandler())
+}
+
+func TestHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		style  HeaderStyle
+		prefix string
+	}{
+		{XHeaders, "X-RateLimit-"},
+		{IETFHeaders, "RateLimit-"},
+	} {
+		lim := rate.New(time.Second * 10)
+		l := Handler(lim, time.Second*3, &Config{Headers: tc.style}, http.NotFoundHandler())
+		tx := httptest.NewRecorder()
+		l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+		for name, want := range map[string]string{"Limit": "10", "Remaining": "7", "Reset": "3"} {
+			if v := tx.Header().Get(tc.prefix + name); v != want {
+				t.Errorf("%s%s: want %s, have %q", tc.prefix, name, want, v)
+			}
+		}
+		lim.Close()
+	}
+
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	tx := httptest.NewRecorder()
+	Handler(lim, time.Second, nil, http.NotFoundHandler()).ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+	if h := tx.Header(); h.Get("X-RateLimit-Limit") != "" || h.Get("RateLimit-Limit") != "" {
+		t.Fatalf("headers written by default: %v", h)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	var named []string
+	conf := &Config{
+		Skip: func(rx *http.Request) bool { return rx.URL.Path == "/health" },
+		TaskFunc: func(rx *http.Request) string {
+			named = append(named, rx.URL.Path)
+			return "a"
+		},
+	}
+	l := Handler(rate.Denied(time.Second), time.Second, conf, http.NotFoundHandler())
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/health", http.StatusNotFound},
+		{"/", http.StatusTooManyRequests},
+	} {
+		tx := httptest.NewRecorder()
+		l.ServeHTTP(tx, httptest.NewRequest("GET", tc.path, nil))
+		if tx.Code != tc.want {
+			t.Errorf("%s: bad status: want %d, have %d", tc.path, tc.want, tx.Code)
+		}
+	}
+	if len(named) != 1 || named[0] != "/" {
+		t.Fatalf("TaskFunc ran for a skipped request: %v", named)
+	}
+}
+
+func TestOnLimit(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	var denied []time.Duration
+	conf := &Config{OnLimit: func(rx *http.Request, task string, delay time.Duration) {
+		if task != rx.Host {
+			t.Errorf("bad task: want %q, have %q", rx.Host, task)
+		}
+		denied = append(denied, delay)
+	}}
+	l := Handler(lim, time.Minute, conf, http.NotFoundHandler())
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(denied) != 0 {
+		t.Fatalf("OnLimit ran for an admitted request")
+	}
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(denied) != 1 || denied[0] <= 0 {
+		t.Fatalf("bad OnLimit calls for a denied request: %v", denied)
+	}
+}
+
+func TestJSONError(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	l := Handler(lim, time.Second*40, &Config{Error: JSONError}, http.NotFoundHandler())
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	tx := httptest.NewRecorder()
+	l.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+	if tx.Code != http.StatusTooManyRequests {
+		t.Fatalf("bad status: want %d, have %d", http.StatusTooManyRequests, tx.Code)
+	}
+	if v := tx.Header().Get("Retry-After"); v != "20" {
+		t.Fatalf("bad Retry-After: want 20, have %q", v)
+	}
+	if ct := tx.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("bad content type: %q", ct)
+	}
+	if body := strings.TrimSpace(tx.Body.String()); body != `{"error":"rate limit exceeded","retry_after":20}` {
+		t.Fatalf("bad body: %s", body)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	admitted := true
+	conf := &Config{Error: http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		_, admitted = FromContext(rx.Context())
+	})}
+	var a *Admission
+	l := Handler(lim, time.Second*40, conf, http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		a, _ = FromContext(rx.Context())
+	}))
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if a == nil || a.Task != "example.com" || a.Cost != time.Second*40 || a.Remaining != time.Second*20 {
+		t.Fatalf("bad admission: %+v", a)
+	}
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if admitted {
+		t.Fatalf("denied request has an admission")
+	}
+}
+
+// wrapWriter is a response writer wrapping another, as middleware does
+type wrapWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrapWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestDelayFor(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	var delay, retry time.Duration
+	conf := &Config{Error: http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		delay, retry = DelayFor(wrapWriter{wrapWriter{tx}}), RetryAfter(rx)
+	})}
+	l := Handler(lim, time.Second*40, conf, http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		if DelayFor(tx) != 0 || RetryAfter(rx) != 0 {
+			t.Errorf("admitted request has a delay")
+		}
+	}))
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if delay <= time.Second*19 || delay > time.Second*20 || retry != delay {
+		t.Fatalf("bad delay: want about 20s from DelayFor and RetryAfter, have %s and %s", delay, retry)
+	}
+	if DelayFor(httptest.NewRecorder()) != 0 {
+		t.Fatalf("DelayFor found a delay in an unrelated writer")
+	}
+}
+
+func TestChargeOn(t *testing.T) {
+	lim := rate.New(time.Minute * 2)
+	defer lim.Close()
+	conf := &Config{ChargeOn: func(code int) bool { return code < 400 }}
+	l := Handler(lim, time.Minute, conf, http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+		if rx.URL.Path == "/fail" {
+			tx.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	serve := func(path string) int {
+		tx := httptest.NewRecorder()
+		l.ServeHTTP(tx, httptest.NewRequest("GET", path, nil))
+		return tx.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := serve("/fail"); code != http.StatusInternalServerError {
+			t.Fatalf("uncharged request %d: bad status: %d", i, code)
+		}
+	}
+	if serve("/") != http.StatusOK || serve("/") != http.StatusOK {
+		t.Fatalf("uncharged requests were charged")
+	}
+	if code := serve("/"); code != http.StatusTooManyRequests {
+		t.Fatalf("charged requests were refunded: bad status: %d", code)
+	}
+}
+
+func TestByHeader(t *testing.T) {
+	rx := httptest.NewRequest("GET", "/", nil)
+	if task := ByHeader("X-API-Key")(rx); task != "192.0.2.1" {
+		t.Fatalf("no header: want the remote IP, have %q", task)
+	}
+	rx.Header.Set("X-API-Key", "  ")
+	if task := ByHeaderOr("X-API-Key", func(*http.Request) string { return "anon" })(rx); task != "anon" {
+		t.Fatalf("empty header: want the fallback, have %q", task)
+	}
+	rx.Header.Set("X-API-Key", " key1 ")
+	if task := ByHeader("X-API-Key")(rx); task != "key1" {
+		t.Fatalf("header: want %q, have %q", "key1", task)
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	conf := &Config{CostFunc: func(rx *http.Request) time.Duration {
+		return time.Duration(rx.ContentLength) * time.Second
+	}}
+	l := Handler(lim, time.Millisecond, conf, http.NotFoundHandler())
+	l.SetCost(time.Millisecond * 2)
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("0123456789")))
+	if r := lim.Remaining("example.com"); r < time.Second*49 || r > time.Second*51 {
+		t.Fatalf("CostFunc did not override the cost: want about 50s remaining, have %s", r)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	mw := Middleware(lim, time.Minute, nil)
+	a, b := mw(http.NotFoundHandler()), mw(http.NotFoundHandler())
+	for i, tc := range []struct {
+		h    http.Handler
+		want int
+	}{
+		{a, http.StatusNotFound},
+		{a, http.StatusTooManyRequests},
+		{b, http.StatusTooManyRequests},
+	} {
+		tx := httptest.NewRecorder()
+		tc.h.ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+		if tx.Code != tc.want {
+			t.Errorf("%d: bad status: want %d, have %d", i, tc.want, tx.Code)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	header := func(name string) func(*http.Request) string {
+		return func(rx *http.Request) string { return rx.Header.Get(name) }
+	}
+	task := Compose("|", header("A"), header("B"), header("C"))
+	for _, tc := range []struct {
+		a, b, c string
+		want    string
+	}{
+		{"1", "2", "3", "1|2|3"},
+		{"1", "", "3", "1|-|3"},
+		{"", "1", "3", "-|1|3"},
+		{"", "", "", "-|-|-"},
+	} {
+		rx := httptest.NewRequest("GET", "/", nil)
+		rx.Header.Set("A", tc.a)
+		rx.Header.Set("B", tc.b)
+		rx.Header.Set("C", tc.c)
+		if have := task(rx); have != tc.want {
+			t.Errorf("%q %q %q: want %q, have %q", tc.a, tc.b, tc.c, tc.want, have)
+		}
+	}
+}
+
+func TestErrorConfig(t *testing.T) {
+	for _, tc := range []struct {
+		conf        *Config
+		status      int
+		body, ctype string
+	}{
+		{nil, http.StatusTooManyRequests, "Too Many Requests", ""},
+		{&Config{Status: http.StatusServiceUnavailable}, http.StatusServiceUnavailable, "Service Unavailable", ""},
+		{&Config{Body: []byte(`{"busy":true}`), ContentType: "application/json"}, http.StatusTooManyRequests, `{"busy":true}`, "application/json"},
+	} {
+		tx := httptest.NewRecorder()
+		Handler(rate.Denied(time.Second), time.Second, tc.conf, http.NotFoundHandler()).ServeHTTP(tx, httptest.NewRequest("GET", "/", nil))
+		if tx.Code != tc.status || tx.Body.String() != tc.body || tx.Header().Get("Content-Type") != tc.ctype {
+			t.Errorf("bad response: want %d %q %q, have %d %q %q", tc.status, tc.body, tc.ctype,
+				tx.Code, tx.Body.String(), tx.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	l := Handler(lim, time.Minute, &Config{Logger: logger, DenyLevel: slog.LevelWarn}, http.NotFoundHandler())
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad log: want 2 lines, have %q", buf.String())
+	}
+	for i, want := range []string{
+		"level=DEBUG msg=\"rate limit decision\" task=example.com decision=admit delay=0s remote_addr=192.0.2.1:1234",
+		"level=WARN msg=\"rate limit decision\" task=example.com decision=deny delay=",
+	} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d: want %q in %q", i, want, lines[i])
+		}
+	}
+
+	buf.Reset()
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+	l = Handler(lim, time.Minute, nil, http.NotFoundHandler())
+	l.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if buf.Len() != 0 {
+		t.Fatalf("logged without a Logger: %q", buf.String())
+	}
+}
+
+func TestLateTaskFunc(t *testing.T) {
+	lim := rate.New(time.Minute)
+	defer lim.Close()
+	conf := &Config{
+		TaskFunc: func(rx *http.Request) string { return rx.Header.Get("X-Early") },
+		LateTaskFunc: func(rx *http.Request) string {
+			if rx.Header.Get("X-Early") != "" {
+				t.Errorf("LateTaskFunc ran for a request named by TaskFunc")
+			}
+			return "late"
+		},
+	}
+	l := Handler(lim, time.Second*10, conf, http.NotFoundHandler())
+	rx := httptest.NewRequest("GET", "/", nil)
+	l.ServeHTTP(httptest.NewRecorder(), rx)
+	rx.Header.Set("X-Early", "early")
+	l.ServeHTTP(httptest.NewRecorder(), rx)
+	if tasks := lim.Tasks(); len(tasks) != 2 || lim.Remaining("late") > time.Second*55 || lim.Remaining("early") > time.Second*55 {
+		t.Fatalf("bad tasks: %v", tasks)
+	}
+}
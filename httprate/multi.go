@@ -0,0 +1,106 @@
+package httprate
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/as/rate"
+)
+
+// Limit is a limiter enforced by a LimitedHandler along with its own.
+type Limit struct {
+	// Limiter and Cost are as in a LimitedHandler
+	rate.Limiter
+	Cost time.Duration
+
+	// TaskFunc, if set, names the request for this limit. The default is the handler's
+	// TaskFunc.
+	TaskFunc func(*http.Request) string
+}
+
+// HandlerAll is like Handler, but admits a request only if every limit admits it, such
+// as a per-client limit and a global one. A denied request gets the largest delay, and
+// the limits that admitted it are given their cost back if they support Reserve, as
+// the limiter returned by rate.New does. Limits that do not support it keep the cost.
+//
+// The first limit is the handler's Limiter and Cost, and the rate-limit headers and
+// Admission describe it alone. Its TaskFunc, if set, replaces the one in conf. HandlerAll
+// panics if limits is empty.
+func HandlerAll(limits []Limit, conf *Config, handler http.Handler) *LimitedHandler {
+	if len(limits) == 0 {
+		panic(errors.New("httprate: HandlerAll needs at least one limit"))
+	}
+	l := Handler(limits[0].Limiter, limits[0].Cost, conf, handler)
+	if limits[0].TaskFunc != nil {
+		l.TaskFunc = limits[0].TaskFunc
+	}
+	l.Limits = limits[1:]
+	return l
+}
+
+// decision is the outcome of scheduling a request
+type decision struct {
+	delay time.Duration
+
+	// remaining is the task's remaining quantum afterward, if known
+	remaining time.Duration
+	known     bool
+
+	// undo gives the cost back to the limits that admitted the request, if needed by
+	// ChargeOn
+	undo []func()
+}
+
+// schedule schedules the request with every limit, returning the largest delay. The
+// remaining quantum is that of the handler's own limiter, and is taken from ScheduleInfo
+// in the same round trip if the limiter has it.
+func (l *LimitedHandler) schedule(lim rate.Limiter, rx *http.Request, task string, cost time.Duration) (d decision) {
+	if len(l.Limits) == 0 && l.ChargeOn == nil {
+		if si, ok := lim.(interface {
+			ScheduleInfo(task string, slice time.Duration) rate.Info
+		}); ok {
+			info := si.ScheduleInfo(task, cost)
+			return decision{delay: info.Delay, remaining: info.Remaining, known: true}
+		}
+		d.delay = lim.Schedule(task, cost)
+	} else {
+		d.delay = reserve(lim, task, cost, &d.undo)
+		for _, m := range l.Limits {
+			task := task
+			if m.TaskFunc != nil {
+				task = m.TaskFunc(rx)
+			}
+			d.delay = max(d.delay, reserve(m.Limiter, task, m.Cost, &d.undo))
+		}
+		if d.delay > 0 {
+			cancel(d.undo)
+			d.undo = nil
+		}
+	}
+	if d.delay <= 0 || l.Headers != NoHeaders {
+		d.remaining, d.known = remaining(lim, task)
+	}
+	return d
+}
+
+// cancel runs every function in undo
+func cancel(undo []func()) {
+	for _, fn := range undo {
+		fn()
+	}
+}
+
+// reserve schedules the task, appending a function that gives the cost back to undo if
+// the limiter supports it
+func reserve(lim rate.Limiter, task string, cost time.Duration, undo *[]func()) time.Duration {
+	r, ok := lim.(interface {
+		Reserve(task string, slice time.Duration) *rate.Reservation
+	})
+	if !ok {
+		return lim.Schedule(task, cost)
+	}
+	res := r.Reserve(task, cost)
+	*undo = append(*undo, res.Cancel)
+	return res.Delay()
+}
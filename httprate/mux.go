@@ -0,0 +1,46 @@
+package httprate
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/as/rate"
+)
+
+// RouteLimit is the limit applied to the requests matching a route.
+type RouteLimit struct {
+	// Limiter and Cost are as in a LimitedHandler
+	rate.Limiter
+	Cost time.Duration
+
+	// Config has optional settings, such as the route's TaskFunc
+	Config
+}
+
+// Mux returns middleware that limits each request by the route its path matches. The
+// routes are keyed by http.ServeMux patterns, and the most specific match wins, so the
+// "/" route is the default limit for otherwise unmatched paths. Without a "/" route,
+// unmatched requests run the wrapped handler without a limit. Mux panics if a pattern
+// is invalid, as http.ServeMux does.
+//
+// The patterns only choose the limit: every request is passed to the wrapped handler
+// as it came, without the redirects an http.ServeMux would serve. A path that is not
+// in canonical form, such as "/a//b" or "/api" with only an "/api/" route, is limited
+// by the route it would be redirected to.
+func Mux(routes map[string]RouteLimit) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		mux := http.NewServeMux()
+		limited := make(map[string]http.Handler, len(routes))
+		for pattern, r := range routes {
+			mux.Handle(pattern, handler)
+			limited[pattern] = Handler(r.Limiter, r.Cost, &r.Config, handler)
+		}
+		return http.HandlerFunc(func(tx http.ResponseWriter, rx *http.Request) {
+			if _, pattern := mux.Handler(rx); limited[pattern] != nil {
+				limited[pattern].ServeHTTP(tx, rx)
+				return
+			}
+			handler.ServeHTTP(tx, rx)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package httprate
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RemoteIP returns a TaskFunc that names each request by its client's IP address. The
+// X-Forwarded-For and X-Real-IP headers are believed only if the immediate peer is in
+// one of the trusted proxy networks, as anyone else can forge them. The
+// X-Forwarded-For list is read right to left and the first address not in a trusted
+// network is the client. If the list holds an address that does not parse before
+// reaching it, the client is taken from X-Real-IP, or else is the peer. Without trusted
+// proxies, the client is the peer in RemoteAddr.
+func RemoteIP(trustedProxies []net.IPNet) func(*http.Request) string {
+	trusted := func(ip net.IP) bool {
+		for _, n := range trustedProxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(rx *http.Request) string {
+		peer := parseIP(rx.RemoteAddr)
+		if peer == nil {
+			return rx.RemoteAddr
+		}
+		if !trusted(peer) {
+			return peer.String()
+		}
+		if xff := rx.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(strings.Join(xff, ","), ",")
+			var client net.IP
+			for i := len(hops) - 1; i >= 0; i-- {
+				ip := parseIP(hops[i])
+				if ip == nil {
+					// The hops to the left of it cannot be trusted either
+					client = nil
+					break
+				}
+				client = ip
+				if !trusted(ip) {
+					break
+				}
+			}
+			if client != nil {
+				return client.String()
+			}
+		}
+		if ip := parseIP(rx.Header.Get("X-Real-IP")); ip != nil {
+			return ip.String()
+		}
+		return peer.String()
+	}
+}
+
+// parseIP parses an IP address with an optional port, such as "192.0.2.1:80" or
+// "[2001:db8::1]:80", returning nil if there is no valid address
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
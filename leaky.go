@@ -0,0 +1,93 @@
+package rate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// NewLeaky returns a leaky-bucket limiter. Each task's bucket fills by the slice on
+// admission and drains by leakRate every second, and a slice is denied if it would
+// overflow capacity. Unlike New, which admits up to a quantum at once, a full bucket
+// admits work only as fast as it drains, pacing the output smoothly.
+//
+// Like GCRA, each task's state is a single timestamp: the time its bucket empties.
+// It panics if capacity or leakRate is not positive.
+func NewLeaky(capacity time.Duration, leakRate time.Duration) *leaky {
+	if capacity <= 0 {
+		panic(errors.New("rate: leaky bucket capacity must be positive"))
+	}
+	if leakRate <= 0 {
+		panic(errors.New("rate: leak rate must be positive"))
+	}
+	return &leaky{
+		capacity: capacity,
+		leakRate: leakRate,
+		clock:    realClock{},
+		empties:  make(map[string]time.Time, preallocEntries),
+	}
+}
+
+// leaky is a leaky-bucket rate limiter
+type leaky struct {
+	capacity time.Duration
+	leakRate time.Duration
+	clock    Clock
+
+	mu      sync.Mutex
+	empties map[string]time.Time
+	swept   time.Time
+}
+
+// Quantum returns the bucket capacity.
+func (l *leaky) Quantum() time.Duration {
+	return l.capacity
+}
+
+// Schedule adds the slice to the task's bucket if it fits, otherwise it returns the
+// delay until enough has drained for it to fit, or Forever if the slice is larger than
+// the bucket. See interface documentation.
+func (l *leaky) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	if slice > l.capacity {
+		return Forever
+	}
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	empty := l.empties[task]
+	if empty.Before(now) {
+		empty = now
+	}
+	empty = empty.Add(l.drain(slice))
+	if delay = empty.Sub(now) - l.drain(l.capacity); delay > 0 {
+		return delay
+	}
+	l.empties[task] = empty
+	return delay
+}
+
+// Close releases the limiter's resources.
+func (l *leaky) Close() error {
+	return nil
+}
+
+// drain returns the time it takes for level to leak out of a bucket
+func (l *leaky) drain(level time.Duration) time.Duration {
+	return time.Duration(float64(level) * float64(time.Second) / float64(l.leakRate))
+}
+
+// sweep removes empty buckets, at most once per tickInterval
+func (l *leaky) sweep(now time.Time) {
+	if now.Sub(l.swept) < tickInterval {
+		return
+	}
+	l.swept = now
+	for k, empty := range l.empties {
+		if !empty.After(now) {
+			delete(l.empties, k)
+		}
+	}
+}
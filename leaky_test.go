@@ -0,0 +1,43 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaky(t *testing.T) {
+	clock := newFakeClock()
+	l := NewLeaky(time.Second*2, time.Second/2)
+	l.clock = clock
+	defer l.Close()
+	if l.Quantum() != time.Second*2 {
+		t.Fatalf("wrong quantum: want 2s, have %s", l.Quantum())
+	}
+	if !Allow(l, "a") || !Allow(l, "a") {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if delay := l.Schedule("a", time.Second); delay != time.Second*2 {
+		t.Fatalf("1/3: bad delay: want 2s, have %s", delay)
+	}
+	clock.Advance(time.Second)
+	if delay := l.Schedule("a", time.Second); delay != time.Second {
+		t.Fatalf("2/3: bad delay: want 1s, have %s", delay)
+	}
+	clock.Advance(time.Second)
+	if !Allow(l, "a") || Allow(l, "a") {
+		t.Fatalf("3/3: bad pacing: want exactly one slice per 2s")
+	}
+}
+
+func TestLeakyInvalid(t *testing.T) {
+	for _, tc := range [][2]time.Duration{{0, time.Second}, {-time.Second, time.Second}, {time.Second, 0}, {time.Second, -time.Second}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("capacity %s, leak rate %s: NewLeaky did not panic", tc[0], tc[1])
+				}
+			}()
+			NewLeaky(tc[0], tc[1])
+		}()
+	}
+}
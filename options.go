@@ -0,0 +1,81 @@
+package rate
+
+import (
+	"errors"
+	"time"
+)
+
+// Option configures a limiter created by NewWithOptions. An Options value is itself an
+// Option that replaces every setting made before it, so NewWithOptions(q, Options{...})
+// works as it always has.
+type Option interface {
+	apply(*Options)
+}
+
+// apply implements Option
+func (o KeyedOptions[K]) apply(dst *KeyedOptions[K]) {
+	*dst = o
+}
+
+// optionFunc is an Option that changes a single setting
+type optionFunc func(*Options)
+
+func (f optionFunc) apply(o *Options) {
+	f(o)
+}
+
+// WithClock sets Options.Clock. It panics if c is nil.
+func WithClock(c Clock) Option {
+	if c == nil {
+		panic(errors.New("rate: nil clock"))
+	}
+	return optionFunc(func(o *Options) { o.Clock = c })
+}
+
+// WithSweepInterval sets Options.SweepInterval. It panics if d is not positive.
+func WithSweepInterval(d time.Duration) Option {
+	if d <= 0 {
+		panic(errors.New("rate: sweep interval must be positive"))
+	}
+	return optionFunc(func(o *Options) { o.SweepInterval = d })
+}
+
+// errMaxSweep is the panic value for an invalid Options.MaxSweep
+var errMaxSweep = errors.New("rate: max sweep must be positive or SweepAll")
+
+// WithMaxSweep sets Options.MaxSweep. It panics if n is neither positive nor SweepAll.
+func WithMaxSweep(n int) Option {
+	if n <= 0 && n != SweepAll {
+		panic(errMaxSweep)
+	}
+	return optionFunc(func(o *Options) { o.MaxSweep = n })
+}
+
+// WithMaxTasks sets Options.MaxTasks. It panics if n is not positive.
+func WithMaxTasks(n int) Option {
+	if n <= 0 {
+		panic(errors.New("rate: max tasks must be positive"))
+	}
+	return optionFunc(func(o *Options) { o.MaxTasks = n })
+}
+
+// WithJitter sets Options.Jitter. It panics if d is negative.
+func WithJitter(d time.Duration) Option {
+	if d < 0 {
+		panic(errors.New("rate: jitter must not be negative"))
+	}
+	return optionFunc(func(o *Options) { o.Jitter = d })
+}
+
+// WithOnEvict sets Options.OnEvict. It panics if fn is nil.
+func WithOnEvict(fn func(task string)) Option {
+	if fn == nil {
+		panic(errors.New("rate: nil eviction func"))
+	}
+	return optionFunc(func(o *Options) { o.OnEvict = fn })
+}
+
+// WithName sets Options.Name.
+func WithName(name string) Option {
+	return optionFunc(func(o *Options) { o.Name = name })
+}
@@ -0,0 +1,42 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second, WithClock(clock), WithMaxTasks(1), WithSweepInterval(time.Minute))
+	defer l.Close()
+	Allow(l, "a")
+	Allow(l, "b")
+	if tasks := l.Tasks(); len(tasks) != 1 || tasks[0] != "b" {
+		t.Fatalf("bad tasks: want [b], have %v", tasks)
+	}
+	if l.sweep != time.Minute || l.clock != clock {
+		t.Fatalf("options not applied")
+	}
+
+	l2 := NewWithOptions(time.Second, WithMaxTasks(1), Options{Clock: clock})
+	defer l2.Close()
+	if l2.maxTasks != 0 {
+		t.Fatalf("Options value did not replace earlier options")
+	}
+
+	for _, fn := range []func(){
+		func() { WithSweepInterval(0) },
+		func() { WithMaxTasks(-1) },
+		func() { WithMaxSweep(0) },
+		func() { WithClock(nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("invalid option did not panic")
+				}
+			}()
+			fn()
+		}()
+	}
+}
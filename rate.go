@@ -2,13 +2,41 @@
 package rate
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Forever is the delay Schedule returns for a task that can never be admitted: either
+// the limiter is closed, or the slice is larger than the quantum, so no amount of
+// waiting would make room for it. Callers retrying on a positive delay should give up
+// on Forever.
+const Forever = time.Duration(math.MaxInt64)
+
+// ErrExceedsQuantum is returned when waiting for a slice larger than the limiter's
+// quantum, which can never be admitted.
+var ErrExceedsQuantum = errors.New("rate: slice exceeds quantum")
+
+// ErrInvalidQuantum is returned by NewChecked for a quantum that is not positive.
+var ErrInvalidQuantum = errors.New("rate: quantum must be positive")
+
+// ErrClosed is returned by ScheduleErr and Wait for a closed limiter. Schedule denies
+// every task with the delay Forever instead.
+var ErrClosed = errors.New("rate: limiter closed")
+
 var (
 	tickInterval    = time.Second * 3
 	preallocEntries = 64
 	maxSweep        = 10
+	historyEntries  = 16
 )
 
 // Limiter provides a way to schedule named tasks for execution.
@@ -27,12 +55,23 @@ type Limiter interface {
 	// If the delay is <= 0 the task can run immediately and the time slice provided
 	// is subtracted from the task's quantum. If delay is > 0, the caller may wait the delay
 	// and attempt to schedule the task again, otherwise the task should be abandoned.
+	// The size of a positive delay relative to the quantum tells whether a retry can
+	// succeed soon or not for a long time. See Severity.
 	Schedule(task string, slice time.Duration) (delay time.Duration)
 
 	// Close closes the limiter
 	Close() error
 }
 
+// TimedLimiter is a Limiter that can evaluate a task at a given time, such as the one
+// returned by New.
+type TimedLimiter interface {
+	Limiter
+
+	// ScheduleAt is like Schedule, but evaluates the task at the given time.
+	ScheduleAt(task string, slice time.Duration, at time.Time) (delay time.Duration)
+}
+
 // Allow returns true if task may execute for 1s at time.Now()
 func Allow(l Limiter, task string) bool {
 	return l.Schedule(task, time.Second) <= 0
@@ -43,108 +82,1334 @@ func AllowSlice(l Limiter, task string, slice time.Duration) bool {
 	return l.Schedule(task, slice) <= 0
 }
 
+// AllowAt returns true if task may execute for 1s at the given time
+func AllowAt(l TimedLimiter, task string, at time.Time) bool {
+	return l.ScheduleAt(task, time.Second, at) <= 0
+}
+
+// AllowSliceAt returns true if task may execute for the slice duration at the given time
+func AllowSliceAt(l TimedLimiter, task string, slice time.Duration, at time.Time) bool {
+	return l.ScheduleAt(task, slice, at) <= 0
+}
+
+// AllowN returns true if task may execute for n seconds at time.Now(). The n units are
+// admitted all at once or not at all.
+func AllowN(l Limiter, task string, n int) bool {
+	return l.Schedule(task, time.Duration(n)*time.Second) <= 0
+}
+
+// Wait blocks until the task is admitted for the slice. On a nil return the slice has
+// been consumed from the task's quantum. It returns ErrExceedsQuantum if the slice can
+// never be admitted, such as one larger than the limiter's quantum, and ErrClosed if the
+// limiter is closed.
+func Wait(l Limiter, task string, slice time.Duration) error {
+	return wait(context.Background(), l, task, slice)
+}
+
+// WaitFor is like Wait, but gives up after timeout and returns context.DeadlineExceeded.
+func WaitFor(l Limiter, task string, slice, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return wait(ctx, l, task, slice)
+}
+
+// scheduler is the part of a Limiter used by wait, for tasks identified by keys of type K
+type scheduler[K comparable] interface {
+	Quantum() time.Duration
+	Schedule(task K, slice time.Duration) (delay time.Duration)
+}
+
+// wait schedules the task until it is admitted or ctx is done. Each iteration sleeps
+// for the delay returned by Schedule. A delay of Forever ends the wait with the error
+// from ScheduleErr if the limiter has one, and ErrClosed otherwise. Limiters without
+// ScheduleErr are assumed to never admit a slice larger than their quantum.
+func wait[K comparable](ctx context.Context, l scheduler[K], task K, slice time.Duration) error {
+	se, _ := l.(interface {
+		ScheduleErr(task K, slice time.Duration) (time.Duration, error)
+	})
+	if se == nil && slice > l.Quantum() {
+		return ErrExceedsQuantum
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var delay time.Duration
+		if se != nil {
+			var err error
+			if delay, err = se.ScheduleErr(task, slice); err != nil {
+				return err
+			}
+		} else if delay = l.Schedule(task, slice); delay == Forever {
+			return ErrClosed
+		}
+		if delay <= 0 {
+			return nil
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Clock is a source of time for a limiter.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a channel that delivers ticks every d, and a function
+	// that stops the ticks.
+	NewTicker(d time.Duration) (tick <-chan time.Time, stop func())
+}
+
+// realClock is the Clock backed by package time
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
 // New returns a limiter that allows task to run for the specified quantum
 // Calls to Allow and AllowSlice reduce a task's available quantum if that
 // task is allowed to run. The quantum is replenished naturally via the passage
-// of time.
+// of time. New panics if quantum is not positive; use NewChecked for quanta that are not
+// known to be valid.
 func New(quantum time.Duration) *limiter {
-	l := &limiter{
-		quantum:  quantum,
-		schedule: make(chan ask, 1),
-		closecap: make(chan bool, 1),
-		done:     make(chan bool),
+	return NewWithOptions(quantum)
+}
+
+// NewChecked is like New, but returns ErrInvalidQuantum instead of panicking if quantum
+// is not positive. Zero is rejected too: a limiter with no quantum would deny every
+// slice except an empty one, forever.
+func NewChecked(quantum time.Duration) (*limiter, error) {
+	if quantum <= 0 {
+		return nil, ErrInvalidQuantum
+	}
+	return New(quantum), nil
+}
+
+// NewWithClock is like New, but the limiter takes its notion of time, including the
+// ticks that drive the sweeping of stale tasks, from the given clock.
+func NewWithClock(quantum time.Duration, clock Clock) *limiter {
+	return NewWithOptions(quantum, Options{Clock: clock})
+}
+
+// SweepAll is the Options.MaxSweep value that sweeps the entire map on each tick.
+const SweepAll = -1
+
+// Options configures a limiter. The zero value of each field selects its default.
+type Options = KeyedOptions[string]
+
+// KeyedOptions configures a limiter for tasks identified by keys of type K. See Options.
+type KeyedOptions[K comparable] struct {
+	// Clock is the limiter's source of time. The default is the system clock.
+	Clock Clock
+
+	// EntryTTL, if positive, is how long a task is kept after it was last scheduled
+	// before a sweep removes it, whether or not its quantum has replenished. A TTL
+	// longer than the quantum retains idle tasks, and one shorter forgets tasks that
+	// are still rate limited. The default sweeps a task once its quantum replenishes.
+	EntryTTL time.Duration
+
+	// Events, if positive, enables the channel returned by Events and sets the
+	// size of its buffer. Without it, no events are made.
+	Events int
+
+	// Fair, if set, serves concurrent requests for different tasks in order of their
+	// last admission, least recent first, so a busy task cannot keep others waiting on
+	// the limiter's goroutine. It costs a sort of the waiting requests and an extra
+	// map entry per task.
+	Fair bool
+
+	// Granularity, if positive, makes quantum replenish in discrete steps instead of
+	// continuously: the limiter's notion of now is truncated to a multiple of Granularity,
+	// so a task's quantum grows by Granularity at each boundary and admissions within a
+	// step see the same time. Positive delays are measured from the start of the current
+	// step and rounded up to whole steps, so they may overshoot by up to one step. It
+	// should be much smaller than the quantum. The default replenishes continuously.
+	Granularity time.Duration
+
+	// Jitter, if positive, pads every positive delay by a random amount in [0, Jitter],
+	// spreading out the retries of tasks denied at the same time. Delays are never
+	// shortened, so admission is unaffected.
+	Jitter time.Duration
+
+	// Rand is the source of jitter. The default is the math/rand top-level source;
+	// a seeded source makes jitter deterministic.
+	Rand *rand.Rand
+
+	// MaxSweep is the maximum number of tasks examined by each sweep. SweepAll
+	// examines every task, and other negative values are invalid. The default is 10.
+	MaxSweep int
+
+	// MaxTasks, if positive, is the maximum number of tasks tracked at once. Adding a
+	// task beyond it first evicts the least recently scheduled task, whose quantum is
+	// then fully available again. This bounds memory under a flood of distinct tasks.
+	MaxTasks int
+
+	// Name, if set, labels the limiter in Stats and String, so that the limiters of a
+	// process running many can be told apart in logs and metrics. The default is empty.
+	Name string
+
+	// NearLimit, if positive, is the fraction of its quantum a task must have consumed
+	// for ScheduleInfo to report it as near its limit, such as 0.8. The default never
+	// reports it.
+	NearLimit float64
+
+	// OnEvict, if set, is called with the name of each task removed from the limiter,
+	// whether by a sweep, MaxTasks eviction, or Reset. It runs on a dedicated goroutine
+	// outside the scheduling critical section, so it may call back into the limiter,
+	// and tasks evicted just before Close may not be reported.
+	OnEvict func(task K)
+
+	// OnSweep, if set, is called with the statistics of each sweep when it finishes. It
+	// runs on the limiter's goroutine and blocks scheduling, so it must return quickly
+	// and must not call back into the limiter.
+	OnSweep func(SweepStats)
+
+	// RateHalfLife is the half-life of the moving average reported by RateEstimate. The
+	// default is one minute.
+	RateHalfLife time.Duration
+
+	// ScheduleBuffer is the number of schedules that may wait for the limiter's goroutine
+	// without blocking their callers on the send. A larger buffer parks fewer goroutines
+	// under bursts, but the schedules are still decided one at a time. The default is 1.
+	ScheduleBuffer int
+
+	// SweepInterval is how often tasks with a fully replenished quantum are swept from
+	// the limiter. Frequent sweeps bound memory at the cost of CPU. The default is 3s.
+	SweepInterval time.Duration
+}
+
+// NewWithOptions is like New, but configured with opts, applied in order. With no
+// options it is equivalent to New. It also panics if Options.MaxSweep is invalid.
+func NewWithOptions(quantum time.Duration, opts ...Option) *limiter {
+	var o Options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return NewKeyedWithOptions(quantum, o)
+}
+
+// NewKeyed is like New, but tasks are identified by keys of any comparable type, such
+// as a struct of a user ID and a route, instead of by name.
+func NewKeyed[K comparable](quantum time.Duration) *keyed[K] {
+	return NewKeyedWithOptions(quantum, KeyedOptions[K]{})
+}
+
+// NewKeyedWithOptions is like NewKeyed, but configured with opts.
+func NewKeyedWithOptions[K comparable](quantum time.Duration, opts KeyedOptions[K]) *keyed[K] {
+	return newKeyed(context.Background(), quantum, opts)
+}
+
+// NewWithContext is like New, but the limiter is closed when ctx is done, so a limiter
+// tied to the lifetime of a server does not leak its goroutine if Close is never
+// called. Calling Close as well is safe.
+func NewWithContext(ctx context.Context, quantum time.Duration) *limiter {
+	return newKeyed(ctx, quantum, Options{})
+}
+
+// newKeyed returns a limiter configured with opts that closes itself when ctx is done.
+// It panics if the quantum or MaxSweep is invalid.
+func newKeyed[K comparable](ctx context.Context, quantum time.Duration, opts KeyedOptions[K]) *keyed[K] {
+	if quantum <= 0 {
+		panic(ErrInvalidQuantum)
+	}
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = tickInterval
+	}
+	if opts.MaxSweep == 0 {
+		opts.MaxSweep = maxSweep
+	} else if opts.MaxSweep < SweepAll {
+		panic(errMaxSweep)
+	}
+	if opts.RateHalfLife <= 0 {
+		opts.RateHalfLife = time.Minute
+	}
+	if opts.ScheduleBuffer <= 0 {
+		opts.ScheduleBuffer = 1
+	}
+	l := &keyed[K]{
+		clock:     opts.Clock,
+		sweep:     opts.SweepInterval,
+		maxSweep:  opts.MaxSweep,
+		maxTasks:  opts.MaxTasks,
+		fair:      opts.Fair,
+		onSweep:   opts.OnSweep,
+		nearLimit: opts.NearLimit,
+		grain:     opts.Granularity,
+		name:      opts.Name,
+		halfLife:  opts.RateHalfLife,
+		ttl:       opts.EntryTTL,
+		jitter:    opts.Jitter,
+		rand:      opts.Rand,
+		order:     list.New(),
+		lru:       make(map[K]*list.Element),
+		schedule:  make(chan ask[K], opts.ScheduleBuffer),
+		control:   make(chan func(), 1),
+		done:      make(chan bool),
+		exited:    make(chan bool),
+		drain:     make(chan bool),
+		ctxDone:   ctx.Done(),
+	}
+	l.quantum.Store(int64(quantum))
+	if opts.Events > 0 {
+		l.events = make(chan KeyedEvent[K], opts.Events)
+	}
+	if opts.OnEvict != nil {
+		l.onEvict = newNotifier(opts.OnEvict)
+		go l.onEvict.run(l.done)
 	}
-	l.closecap <- true
 	go l.run()
 	return l
 }
 
 // limiter is a rate limiter
-type limiter struct {
-	quantum        time.Duration
-	schedule       chan ask
-	closecap, done chan bool
+type limiter = keyed[string]
+
+// keyed is a rate limiter for tasks identified by keys of type K
+type keyed[K comparable] struct {
+	quantum   atomic.Int64
+	clock     Clock
+	sweep     time.Duration
+	maxSweep  int
+	onSweep   func(SweepStats)
+	nearLimit float64
+	grain     time.Duration
+	name      string
+	schedule  chan ask[K]
+	control   chan func()
+	done      chan bool
+	closeOnce sync.Once
+	exited    chan bool
+	err       error // set before exited is closed
+	drain     chan bool
+	drainOnce sync.Once
+	ctxDone   <-chan struct{}
+
+	// jitter and rand pad positive delays; rand is used on the run goroutine
+	jitter time.Duration
+	rand   *rand.Rand
+
+	// ttl, if positive, sweeps tasks by lastSeen, the time each task was last
+	// scheduled, instead of by mark; lastSeen is owned by the run goroutine
+	ttl      time.Duration
+	lastSeen map[K]time.Time
+
+	// fair orders waiting asks by admits, the sequence number of each task's last
+	// admission; admits and pending are owned by the run goroutine
+	fair    bool
+	seq     uint64
+	admits  map[K]uint64
+	pending []ask[K]
+
+	// rate holds the bits of the float64 admission rate estimate, sampled from the
+	// accepted counter on each tick; sampled and sampledAt are owned by the run goroutine
+	rate      atomic.Uint64
+	halfLife  time.Duration
+	sampled   uint64
+	sampledAt time.Time
+
+	// counters behind Stats
+	accepted, denied, evicted, displaced, dropped atomic.Uint64
+
+	// events, if set, receives events from the run goroutine
+	events chan KeyedEvent[K]
+
+	// marks, watched, weights, and cursor are owned by the run goroutine
+	marks   map[K]time.Time
+	watched map[K]*history
+	weights map[K]float64
+
+	// bans holds the time each banned task's ban ends, owned by the run goroutine.
+	// Expired bans are removed by sweepBans.
+	bans map[K]time.Time
+
+	// last is the latest time returned by now, owned by the run goroutine
+	last time.Time
+
+	// cursor holds the tasks left to examine in the current sweep cycle
+	cursor []K
+
+	// lru orders the tracked tasks by their last schedule, most recent first, when
+	// maxTasks is set
+	maxTasks int
+	order    *list.List
+	lru      map[K]*list.Element
+
+	// removed holds the tasks removed since the last delivery to onEvict
+	onEvict *notifier[K]
+	removed []K
 }
 
 // Schedule schedules the task to run for the given time slice if there is quantum. See interface
 // documentation.
-func (l *limiter) Schedule(task string, slice time.Duration) (delay time.Duration) {
-	reply := make(chan time.Duration, 1)
-	l.schedule <- ask{
-		string:   task,
-		Duration: slice,
-		reply:    reply,
+func (l *keyed[K]) Schedule(task K, slice time.Duration) (delay time.Duration) {
+	return l.send(ask[K]{task: task, slice: slice})
+}
+
+// ScheduleErr is like Schedule, but reports a task that can never be admitted with an
+// error alongside the delay Forever: ErrClosed if the limiter is closed, and
+// ErrExceedsQuantum if the slice is larger than the quantum.
+func (l *keyed[K]) ScheduleErr(task K, slice time.Duration) (time.Duration, error) {
+	delay, err := l.sendContext(context.Background(), ask[K]{task: task, slice: slice})
+	if err == nil && delay == Forever {
+		err = ErrExceedsQuantum
 	}
-	return <-reply
+	return delay, err
+}
+
+// ScheduleCtx is like Schedule, but gives up if ctx is done before the limiter's goroutine
+// takes the request, returning the delay Forever and ctx.Err(). Once taken, the request
+// is decided and its delay returned even if ctx is done meanwhile, as the decision is
+// quick and may already have consumed the slice. A closed limiter returns ErrClosed.
+func (l *keyed[K]) ScheduleCtx(ctx context.Context, task K, slice time.Duration) (time.Duration, error) {
+	return l.sendContext(ctx, ask[K]{task: task, slice: slice})
+}
+
+// ScheduleAt is like Schedule, but evaluates the task at the given time instead of
+// the clock's current time, and stores its mark relative to that time. Feeding a
+// timeline of calls through ScheduleAt simulates traffic deterministically.
+func (l *keyed[K]) ScheduleAt(task K, slice time.Duration, at time.Time) (delay time.Duration) {
+	return l.send(ask[K]{task: task, slice: slice, at: at})
 }
 
-func (l *limiter) Quantum() time.Duration {
-	return l.quantum
+// Info is the outcome of ScheduleInfo.
+type Info struct {
+	// Delay is the delay returned by Schedule
+	Delay time.Duration
+
+	// Remaining is the quantum remaining to the task afterward: after consuming the
+	// slice if it was admitted, and as it stands otherwise
+	Remaining time.Duration
+
+	// NearLimit is true if the task has consumed at least Options.NearLimit of its
+	// quantum, so it is close to being denied
+	NearLimit bool
+}
+
+// ScheduleInfo is like Schedule, but also describes the task's quantum afterward.
+func (l *keyed[K]) ScheduleInfo(task K, slice time.Duration) (info Info) {
+	info.Delay = Forever
+	l.do(func() {
+		now := l.now()
+		info.Delay = l.decide(task, slice, now)
+		info.Remaining = l.remaining(l.mark(task), now)
+		if l.nearLimit > 0 {
+			used := float64(l.Quantum()-info.Remaining) / float64(l.Quantum())
+			info.NearLimit = used >= l.nearLimit
+		}
+	})
+	return info
+}
+
+// Request is a task and slice to schedule in a batch.
+type Request = KeyedRequest[string]
+
+// KeyedRequest is a Request for a task identified by a key of type K.
+type KeyedRequest[K comparable] struct {
+	Task  K
+	Slice time.Duration
 }
 
-// Close releases the rate limiter's resources.
-func (l *limiter) Close() error {
+// ScheduleBatch schedules each request independently, as Schedule would, in a single
+// round trip to the limiter's goroutine. The delays align index for index with reqs.
+func (l *keyed[K]) ScheduleBatch(reqs []KeyedRequest[K]) []time.Duration {
+	delays := make([]time.Duration, len(reqs))
+	for i := range delays {
+		delays[i] = Forever
+	}
+	l.do(func() {
+		now := l.now()
+		for i, r := range reqs {
+			delays[i] = l.decide(r.Task, r.Slice, now)
+		}
+	})
+	return delays
+}
+
+// ScheduleAll admits every request or none of them, evaluated atomically on the
+// limiter's goroutine. If every request fits, each slice is consumed and ok is true.
+// Otherwise nothing is consumed and delay is the largest delay among the requests,
+// after which they may all fit. A task may appear more than once.
+func (l *keyed[K]) ScheduleAll(reqs []KeyedRequest[K]) (delay time.Duration, ok bool) {
+	delay = Forever
+	l.do(func() {
+		now := l.now()
+		tentative := make(map[K]time.Time, len(reqs))
+		delay = 0
+		for _, r := range reqs {
+			mark, seen := tentative[r.Task]
+			if !seen {
+				mark = l.mark(r.Task)
+			}
+			then := l.after(mark, r.Task, r.Slice, now)
+			tentative[r.Task] = then
+			delay = max(delay, then.Sub(now), l.banned(r.Task, now))
+			if l.exceeds(r.Task, r.Slice) {
+				delay = Forever
+			}
+		}
+		if ok = delay <= 0; !ok {
+			l.denied.Add(uint64(len(reqs)))
+			for _, r := range reqs {
+				l.emit(r.Task, EventDeny, now)
+			}
+			delay = l.pad(delay)
+			return
+		}
+		for _, r := range reqs {
+			l.decide(r.Task, r.Slice, now)
+		}
+	})
+	return delay, ok
+}
+
+// send passes the ask to the run goroutine and returns its reply
+func (l *keyed[K]) send(a ask[K]) (delay time.Duration) {
+	delay, _ = l.sendContext(context.Background(), a)
+	return delay
+}
+
+// sendContext is like send, but gives up waiting to pass the ask to the run goroutine
+// when ctx is done
+func (l *keyed[K]) sendContext(ctx context.Context, a ask[K]) (delay time.Duration, err error) {
+	select {
+	case <-l.drain:
+		return Forever, ErrClosed
+	default:
+	}
+	// The sends below race with done, so a closed limiter is caught here first
+	if l.closed() {
+		return Forever, ErrClosed
+	}
+	reply := replies.Get().(chan time.Duration)
+	a.reply = reply
 	select {
-	case first := <-l.closecap:
-		if first {
-			close(l.closecap)
-			close(l.done)
+	case l.schedule <- a:
+	case <-ctx.Done():
+		replies.Put(reply)
+		return Forever, ctx.Err()
+	case <-l.done:
+		replies.Put(reply)
+		return Forever, ErrClosed
+	case <-l.exited:
+		replies.Put(reply)
+		return Forever, ErrClosed
+	}
+	select {
+	case delay = <-reply:
+	case <-l.exited:
+		// The run goroutine may have replied before exiting
+		select {
+		case <-reply:
+		default:
 		}
+		delay, err = Forever, ErrClosed
+	}
+
+	// The run goroutine sends at most once per ask, so the drained channel
+	// is safe to reuse
+	replies.Put(reply)
+	return delay, err
+}
+
+// replies pools the buffered reply channels used by Schedule
+var replies = sync.Pool{
+	New: func() any {
+		return make(chan time.Duration, 1)
+	},
+}
+
+// WaitContext blocks until the task is admitted for the slice, or ctx is done. It returns
+// ctx.Err() on cancellation, in which case no quantum is consumed, and ErrExceedsQuantum
+// if the slice can never fit within the quantum.
+func (l *keyed[K]) WaitContext(ctx context.Context, task K, slice time.Duration) error {
+	return wait(ctx, l, task, slice)
+}
+
+// Peek returns the delay Schedule would return for the task and slice, without
+// consuming any quantum.
+func (l *keyed[K]) Peek(task K, slice time.Duration) (delay time.Duration) {
+	l.do(func() {
+		_, delay = l.next(task, slice, l.now())
+	})
+	return delay
+}
+
+// SetWeight makes scheduling a slice for the task consume slice×w of its quantum, so
+// expensive tasks can cost more without changing the slice at every call site. The
+// weight defaults to 1 and is cleared when the task is swept or reset. SetWeight panics
+// if w is not positive, as such a weight would admit the task for free.
+func (l *keyed[K]) SetWeight(task K, w float64) {
+	if !(w > 0) {
+		panic(errors.New("rate: weight must be positive"))
+	}
+	l.do(func() {
+		if w == 1 {
+			delete(l.weights, task)
+			return
+		}
+		l.weights[task] = w
+	})
+}
+
+// Ban denies the task until the given time, even if it has quantum available, such as to
+// shut out an abusive client for a while. Schedule returns at least the delay until the
+// ban ends, and the ban is swept once it has. Reset does not lift a ban; Unban does.
+// Banning a banned task replaces its ban.
+func (l *keyed[K]) Ban(task K, until time.Time) {
+	l.do(func() {
+		l.bans[task] = until
+	})
+}
+
+// Unban lifts the task's ban, if any.
+func (l *keyed[K]) Unban(task K) {
+	l.do(func() {
+		delete(l.bans, task)
+	})
+}
+
+// Reset forgives the task, making its full quantum available immediately. Resetting an
+// unknown task has no effect.
+func (l *keyed[K]) Reset(task K) {
+	l.do(func() {
+		l.remove(task)
+	})
+}
+
+// ResetAll forgives every task, as Reset does. OnEvict is called for each of them.
+func (l *keyed[K]) ResetAll() {
+	l.ResetMatching(func(K) bool { return true })
+}
+
+// ResetMatching forgives every task for which match returns true, as Reset does. OnEvict
+// is called for each of them. Match runs on the limiter's goroutine and blocks
+// scheduling, so it must not call back into the limiter.
+func (l *keyed[K]) ResetMatching(match func(task K) bool) {
+	l.do(func() {
+		for k := range l.marks {
+			if match(k) {
+				l.remove(k)
+			}
+		}
+	})
+}
+
+// Remaining returns the quantum available to the task at time.Now() without consuming
+// any of it. A task that has never been seen has the full quantum available.
+func (l *keyed[K]) Remaining(task K) (d time.Duration) {
+	l.do(func() {
+		d = l.remaining(l.mark(task), l.now())
+	})
+	return d
+}
+
+// Has returns true if the limiter is tracking the task: it has consumed quantum and has
+// not yet been swept, evicted, or reset. OnEvict reports each task as it stops being
+// tracked.
+func (l *keyed[K]) Has(task K) (ok bool) {
+	l.do(func() {
+		_, ok = l.marks[task]
+	})
+	return ok
+}
+
+// Len returns the number of tasks currently tracked by the limiter.
+func (l *keyed[K]) Len() (n int) {
+	l.do(func() {
+		n = len(l.marks)
+	})
+	return n
+}
+
+// Cap returns the number of tasks the limiter preallocates space for.
+func (l *keyed[K]) Cap() int {
+	return preallocEntries
+}
+
+// Tasks returns the names of the tasks currently tracked by the limiter, in no
+// particular order.
+func (l *keyed[K]) Tasks() (tasks []K) {
+	l.do(func() {
+		tasks = make([]K, 0, len(l.marks))
+		for k := range l.marks {
+			tasks = append(tasks, k)
+		}
+	})
+	return tasks
+}
+
+// TasksFunc calls fn for each tracked task and its remaining quantum until fn returns
+// false. The tasks are visited in no particular order. Fn runs on the limiter's goroutine
+// and blocks scheduling, so it must not call back into the limiter.
+func (l *keyed[K]) TasksFunc(fn func(task K, remaining time.Duration) bool) {
+	l.do(func() {
+		now := l.now()
+		for k, v := range l.marks {
+			if !fn(k, l.remaining(v, now)) {
+				return
+			}
+		}
+	})
+}
+
+// Snapshot returns a copy of the limiter's task marks, for seeding another limiter
+// with Restore.
+func (l *keyed[K]) Snapshot() (marks map[K]time.Time) {
+	l.do(func() {
+		marks = maps.Clone(l.marks)
+	})
+	return marks
+}
+
+// Restore seeds the limiter with task marks taken by Snapshot, replacing the marks of any
+// tasks already present. Marks whose quantum has since replenished are harmless: they admit
+// the task exactly as an unknown task would, and are removed by the sweeps as usual.
+func (l *keyed[K]) Restore(marks map[K]time.Time) {
+	l.do(func() {
+		for k, v := range marks {
+			l.set(k, v)
+		}
+	})
+}
+
+// Stats holds a limiter's counters.
+type Stats struct {
+	// Name is the limiter's Options.Name
+	Name string
+
+	// Accepted and Denied count the scheduling decisions made
+	Accepted, Denied uint64
+
+	// Evicted counts the tasks removed by sweeps
+	Evicted uint64
+
+	// Displaced counts the tasks evicted to stay within Options.MaxTasks
+	Displaced uint64
+
+	// Dropped counts the events not delivered because the Events buffer was full
+	Dropped uint64
+}
+
+// Stats returns the limiter's counters since it was created or last reset.
+func (l *keyed[K]) Stats() Stats {
+	return Stats{
+		Name:      l.name,
+		Accepted:  l.accepted.Load(),
+		Denied:    l.denied.Load(),
+		Evicted:   l.evicted.Load(),
+		Displaced: l.displaced.Load(),
+		Dropped:   l.dropped.Load(),
+	}
+}
+
+// Name returns the limiter's Options.Name.
+func (l *keyed[K]) Name() string {
+	return l.name
+}
+
+// ResetStats zeroes the limiter's counters. The name is kept.
+func (l *keyed[K]) ResetStats() {
+	l.accepted.Store(0)
+	l.denied.Store(0)
+	l.evicted.Store(0)
+	l.displaced.Store(0)
+	l.dropped.Store(0)
+}
+
+// Watch starts recording the last few scheduling decisions made for task. Watching
+// an already watched task keeps its existing history.
+func (l *keyed[K]) Watch(task K) {
+	l.do(func() {
+		if l.watched[task] == nil {
+			l.watched[task] = &history{}
+		}
+	})
+}
+
+// Unwatch stops recording decisions for task and discards its history.
+func (l *keyed[K]) Unwatch(task K) {
+	l.do(func() {
+		delete(l.watched, task)
+	})
+}
+
+// History returns the recorded decisions for a watched task, oldest first. It returns
+// nil if the task is not being watched.
+func (l *keyed[K]) History(task K) (d []Decision) {
+	l.do(func() {
+		if h := l.watched[task]; h != nil {
+			d = h.list()
+		}
+	})
+	return d
+}
+
+// do runs fn on the run goroutine and waits for it to return.
+func (l *keyed[K]) do(fn func()) {
+	if l.closed() {
+		return
+	}
+	done := make(chan bool)
+	select {
+	case l.control <- func() {
+		fn()
+		close(done)
+	}:
+	case <-l.done:
+		return
+	case <-l.exited:
+		return
+	}
+	select {
+	case <-done:
+	case <-l.exited:
+	}
+}
+
+func (l *keyed[K]) Quantum() time.Duration {
+	return time.Duration(l.quantum.Load())
+}
+
+// SetQuantum changes the limiter's quantum. Schedules that follow it use the new
+// quantum, and existing task marks are floored against it from then on. SetQuantum
+// panics with ErrInvalidQuantum if quantum is not positive.
+func (l *keyed[K]) SetQuantum(quantum time.Duration) {
+	if quantum <= 0 {
+		panic(ErrInvalidQuantum)
+	}
+	l.do(func() {
+		l.quantum.Store(int64(quantum))
+	})
+}
+
+// Err returns a non-nil error if the limiter's goroutine has crashed, for instance
+// because a callback run on it panicked. A crashed limiter behaves as though it was
+// closed.
+func (l *keyed[K]) Err() error {
+	select {
+	case <-l.exited:
+		return l.err
 	default:
+		return nil
 	}
+}
+
+// Close releases the rate limiter's resources. It is safe to call Close concurrently
+// and more than once, and every call returns only once the limiter is closed. After
+// Close, Schedule denies every task with the delay Forever, ScheduleErr returns
+// ErrClosed, and methods that inspect or modify its tasks do nothing and return zero
+// values.
+func (l *keyed[K]) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
 	return nil
 }
 
-func (l *limiter) run() {
-	m := make(map[string]time.Time, preallocEntries)
-	tick := time.NewTicker(tickInterval)
+// closed returns true if the limiter has been closed or its goroutine has exited
+func (l *keyed[K]) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	case <-l.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseContext stops the limiter from accepting new schedules, waits for queued
+// schedules to be answered, and then closes the limiter. If ctx is done first, the
+// limiter is closed at once, the requests still queued are denied with the delay
+// Forever, and their number is returned along with ctx.Err().
+func (l *keyed[K]) CloseContext(ctx context.Context) (dropped int, err error) {
+	l.drainOnce.Do(func() {
+		close(l.drain)
+	})
+	select {
+	case <-l.exited:
+		return 0, nil
+	case <-ctx.Done():
+		dropped = len(l.schedule)
+		l.Close()
+		return dropped, ctx.Err()
+	}
+}
 
-	defer close(l.schedule)
-	defer tick.Stop()
+func (l *keyed[K]) run() {
+	defer close(l.exited)
+	if l.events != nil {
+		defer close(l.events)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			l.err = fmt.Errorf("rate: limiter failed: %v", r)
+		}
+	}()
+	l.marks = make(map[K]time.Time, preallocEntries)
+	l.watched = make(map[K]*history)
+	l.weights = make(map[K]float64)
+	l.admits = make(map[K]uint64)
+	l.lastSeen = make(map[K]time.Time)
+	l.bans = make(map[K]time.Time)
+	l.sampledAt = l.now()
+	tick, stop := l.clock.NewTicker(l.sweep)
+	drain := l.drain
+
+	defer stop()
 
 	for {
 		select {
 		case ask := <-l.schedule:
-			now := time.Now()
-			then := l.floor(m[ask.string], now).Add(ask.Duration)
-			delta := then.Sub(now)
-			ask.reply <- delta
-			if delta <= 0 {
-				m[ask.string] = then
+			// Asks sent before Close are answered by the exit, as select may pick
+			// them over done
+			if l.closed() {
+				return
 			}
-		case <-tick.C:
-			select {
-			case <-l.done:
+			if l.fair {
+				l.serveFair(ask)
+				break
+			}
+			l.serve(ask)
+		case fn := <-l.control:
+			if l.closed() {
 				return
-			default:
 			}
+			fn()
+		case <-l.done:
+			return
+		case <-l.ctxDone:
+			l.Close()
+			return
+		case <-drain:
+			drain = nil
+		case <-tick:
+			now := l.now()
+			l.sampleRate(now)
+			l.sweepAndReport(now)
+			l.sweepBans(now)
+		}
+		if len(l.removed) > 0 {
+			l.onEvict.add(l.removed)
+			l.removed = l.removed[:0]
+		}
+		if drain == nil && len(l.schedule) == 0 {
+			l.Close()
+			return
+		}
+	}
+}
 
-			// TODO(as): The best number is probably not the current MaxSweep
-			i := 0
-			t := time.Now()
-			for k, v := range m {
-				if l.floor(v, t) != v {
-					delete(m, k)
-				}
-				if i >= maxSweep {
-					break
-				}
-				i++
+// serve replies to a single ask
+func (l *keyed[K]) serve(ask ask[K]) {
+	now := l.snap(ask.at)
+	if now.IsZero() {
+		now = l.now()
+	}
+	ask.reply <- l.decide(ask.task, ask.slice, now)
+}
+
+// serveFair collects the asks waiting behind ask and serves them in fair order
+func (l *keyed[K]) serveFair(ask ask[K]) {
+	l.pending = append(l.pending[:0], ask)
+	for len(l.pending) < preallocEntries {
+		select {
+		case ask := <-l.schedule:
+			l.pending = append(l.pending, ask)
+			continue
+		default:
+		}
+		break
+	}
+	l.sortFair(l.pending)
+	for _, ask := range l.pending {
+		l.serve(ask)
+	}
+	clear(l.pending)
+}
+
+// sortFair orders asks by the last admission of their task, least recent first
+func (l *keyed[K]) sortFair(asks []ask[K]) {
+	sort.SliceStable(asks, func(i, j int) bool {
+		return l.admits[asks[i].task] < l.admits[asks[j].task]
+	})
+}
+
+// decide schedules the task for slice at now, consuming the slice if it is admitted
+func (l *keyed[K]) decide(task K, slice time.Duration, now time.Time) (delay time.Duration) {
+	mark, tracked := l.marks[task]
+	then := l.after(mark, task, slice, now)
+	delay = then.Sub(now)
+	if l.exceeds(task, slice) {
+		delay = Forever
+	} else if len(l.bans) > 0 {
+		delay = max(delay, l.banned(task, now))
+	}
+	l.touch(task)
+	if delay <= 0 {
+		if tracked {
+			l.marks[task] = then
+		} else {
+			l.insert(task, then)
+		}
+		l.accepted.Add(1)
+		l.emit(task, EventAdmit, now)
+		if l.fair {
+			l.seq++
+			l.admits[task] = l.seq
+		}
+	} else {
+		l.denied.Add(1)
+		l.emit(task, EventDeny, now)
+	}
+	if l.ttl > 0 && (tracked || delay <= 0) {
+		// Only tracked tasks are swept, and with them their lastSeen
+		l.lastSeen[task] = now
+	}
+	delay = l.pad(delay)
+	if h := l.watched[task]; h != nil {
+		h.add(Decision{At: now, Slice: slice, Delay: delay, Admitted: delay <= 0})
+	}
+	return delay
+}
+
+// pad rounds a positive delay other than Forever up to whole steps and adds jitter
+func (l *keyed[K]) pad(delay time.Duration) time.Duration {
+	if delay <= 0 || delay == Forever {
+		return delay
+	}
+	delay = l.step(delay)
+	if l.jitter <= 0 {
+		return delay
+	}
+	n := int64(l.jitter) + 1
+	if l.rand != nil {
+		return delay + time.Duration(l.rand.Int63n(n))
+	}
+	return delay + time.Duration(rand.Int63n(n))
+}
+
+// RateEstimate returns an exponentially weighted moving average of the number of tasks
+// admitted per second. It is updated once per sweep interval from the counters behind
+// Stats, so it costs nothing to schedule and lags by up to one interval.
+func (l *keyed[K]) RateEstimate() float64 {
+	return math.Float64frombits(l.rate.Load())
+}
+
+// sampleRate folds the admissions since the last sample into the rate estimate
+func (l *keyed[K]) sampleRate(now time.Time) {
+	elapsed := now.Sub(l.sampledAt)
+	if elapsed <= 0 {
+		return
+	}
+	n := l.accepted.Load()
+	admitted := n - l.sampled
+	if n < l.sampled {
+		// ResetStats zeroed the counter since the last sample
+		admitted = n
+	}
+	l.sampled, l.sampledAt = n, now
+	alpha := 1 - math.Exp2(-float64(elapsed)/float64(l.halfLife))
+	rate := math.Float64frombits(l.rate.Load())
+	rate += alpha * (float64(admitted)/elapsed.Seconds() - rate)
+	l.rate.Store(math.Float64bits(rate))
+}
+
+// SweepStats describes a single sweep.
+type SweepStats struct {
+	// Examined and Deleted count the tasks examined and removed by the sweep
+	Examined, Deleted int
+
+	// Duration is how long the sweep took
+	Duration time.Duration
+
+	// MapSize is the number of tasks tracked after the sweep
+	MapSize int
+}
+
+// sweepAndReport sweeps the tasks, reporting the sweep to onSweep if it is set
+func (l *keyed[K]) sweepAndReport(now time.Time) {
+	if l.onSweep == nil {
+		l.sweepTasks(now)
+		return
+	}
+	start := time.Now()
+	examined, deleted := l.sweepTasks(now)
+	l.onSweep(SweepStats{
+		Examined: examined,
+		Deleted:  deleted,
+		Duration: time.Since(start),
+		MapSize:  len(l.marks),
+	})
+}
+
+// sweepTasks removes expired tasks, returning the number examined and deleted. Unless every task is swept
+// at once, each call examines up to maxSweep tasks and resumes where the last call
+// stopped, so a task is examined within len(marks)/maxSweep+1 ticks of being marked.
+func (l *keyed[K]) sweepTasks(now time.Time) (examined, deleted int) {
+	if l.maxSweep == SweepAll {
+		for k, v := range l.marks {
+			examined++
+			if l.sweepTask(k, v, now) {
+				deleted++
+			}
+		}
+		return examined, deleted
+	}
+
+	// TODO(as): The best number is probably not the current MaxSweep
+	if len(l.cursor) == 0 {
+		l.cursor = make([]K, 0, len(l.marks))
+		for k := range l.marks {
+			l.cursor = append(l.cursor, k)
+		}
+	}
+	n := min(l.maxSweep, len(l.cursor))
+	for _, k := range l.cursor[:n] {
+		if v, ok := l.marks[k]; ok {
+			examined++
+			if l.sweepTask(k, v, now) {
+				deleted++
 			}
 		}
 	}
+	l.cursor = l.cursor[n:]
+	return examined, deleted
 }
 
-// floor returns the mark time clamped to [now-window, +inf)
-func (l *limiter) floor(mark time.Time, now time.Time) time.Time {
-	if t := now.Add(-l.quantum); !mark.After(t) {
+// sweepTask deletes the task if it has expired at now, returning true if it did
+func (l *keyed[K]) sweepTask(task K, mark time.Time, now time.Time) bool {
+	if !l.expired(task, mark, now) {
+		return false
+	}
+	l.remove(task)
+	l.evicted.Add(1)
+	return true
+}
+
+// expired returns true if the task should be swept at now. Without a ttl, that is when
+// its mark is stale. With one, it is when the task was last scheduled a ttl ago, or
+// marked a ttl ago if it was restored rather than scheduled.
+func (l *keyed[K]) expired(task K, mark time.Time, now time.Time) bool {
+	if l.ttl <= 0 {
+		return l.stale(mark, now)
+	}
+	seen, ok := l.lastSeen[task]
+	if !ok {
+		seen = mark
+	}
+	return !seen.After(now.Add(-l.ttl))
+}
+
+// set marks the task, evicting the least recently scheduled task first if a new task
+// would exceed maxTasks
+func (l *keyed[K]) set(task K, mark time.Time) {
+	if _, ok := l.marks[task]; ok {
+		l.marks[task] = mark
+		return
+	}
+	l.insert(task, mark)
+}
+
+// insert marks a task known not to be tracked, evicting the least recently scheduled
+// task first if it would exceed maxTasks
+func (l *keyed[K]) insert(task K, mark time.Time) {
+	if l.maxTasks > 0 {
+		if len(l.marks) >= l.maxTasks {
+			oldest := l.order.Back()
+			l.remove(oldest.Value.(K))
+			l.displaced.Add(1)
+		}
+		l.lru[task] = l.order.PushFront(task)
+	}
+	l.marks[task] = mark
+}
+
+// mark returns the task's mark, or the zero time if it is not tracked
+func (l *keyed[K]) mark(task K) time.Time {
+	return l.marks[task]
+}
+
+// touch marks the task as the most recently scheduled
+func (l *keyed[K]) touch(task K) {
+	if e := l.lru[task]; e != nil {
+		l.order.MoveToFront(e)
+	}
+}
+
+// remove deletes the task's mark
+func (l *keyed[K]) remove(task K) {
+	if _, ok := l.marks[task]; ok {
+		if l.onEvict != nil {
+			l.removed = append(l.removed, task)
+		}
+		if l.events != nil {
+			l.emit(task, EventEvict, l.now())
+		}
+	}
+	delete(l.marks, task)
+	delete(l.weights, task)
+	delete(l.admits, task)
+	delete(l.lastSeen, task)
+	if e := l.lru[task]; e != nil {
+		l.order.Remove(e)
+		delete(l.lru, task)
+	}
+}
+
+// next returns the task's mark after running for slice at now, and the delay before
+// it may do so
+func (l *keyed[K]) next(task K, slice time.Duration, now time.Time) (then time.Time, delay time.Duration) {
+	then = l.after(l.mark(task), task, slice, now)
+	if l.exceeds(task, slice) {
+		return then, Forever
+	}
+	return then, l.step(max(then.Sub(now), l.banned(task, now)))
+}
+
+// step rounds a positive delay up to a whole number of granularity steps, so the step
+// that admits the task has begun when the delay ends
+func (l *keyed[K]) step(delay time.Duration) time.Duration {
+	if l.grain <= 0 || delay <= 0 || delay == Forever {
+		return delay
+	}
+	if r := delay % l.grain; r != 0 {
+		delay += l.grain - r
+	}
+	return delay
+}
+
+// snap truncates t to a granularity boundary
+func (l *keyed[K]) snap(t time.Time) time.Time {
+	if l.grain <= 0 {
+		return t
+	}
+	return t.Truncate(l.grain)
+}
+
+// banned returns the time left in the task's ban at now, or zero if it is not banned
+func (l *keyed[K]) banned(task K, now time.Time) time.Duration {
+	if until, ok := l.bans[task]; ok && until.After(now) {
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// sweepBans removes the bans that have expired at now
+func (l *keyed[K]) sweepBans(now time.Time) {
+	for k, until := range l.bans {
+		if !until.After(now) {
+			delete(l.bans, k)
+		}
+	}
+}
+
+// exceeds returns true if running the task for slice consumes more than the quantum,
+// so it can never be admitted
+func (l *keyed[K]) exceeds(task K, slice time.Duration) bool {
+	return l.weigh(task, slice) > l.Quantum()
+}
+
+// after returns the mark of a task marked at mark after running for slice at now
+func (l *keyed[K]) after(mark time.Time, task K, slice time.Duration, now time.Time) time.Time {
+	return l.floor(mark, now).Add(l.weigh(task, slice))
+}
+
+// weigh returns the quantum consumed by running the task for slice
+func (l *keyed[K]) weigh(task K, slice time.Duration) time.Duration {
+	if w, ok := l.weights[task]; ok {
+		return time.Duration(float64(slice) * w)
+	}
+	return slice
+}
+
+// remaining returns the quantum available at now to a task with the given mark
+func (l *keyed[K]) remaining(mark time.Time, now time.Time) time.Duration {
+	if d := now.Sub(l.floor(mark, now)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// stale returns true if a task with the given mark has its full quantum available at
+// now. It compares instants, so marks with and without a monotonic clock reading are
+// treated alike.
+func (l *keyed[K]) stale(mark time.Time, now time.Time) bool {
+	return !mark.After(now.Add(-l.Quantum()))
+}
+
+// floor returns the mark time clamped to [now-quantum, +inf)
+func (l *keyed[K]) floor(mark time.Time, now time.Time) time.Time {
+	return Floor(mark, now, l.Quantum())
+}
+
+// Floor returns the mark clamped to [now-quantum, +inf). This is the core of the limiter:
+// a task marked at mark has now.Sub(Floor(mark, now, quantum)) of its quantum remaining,
+// and running it for a slice moves its mark to Floor(mark, now, quantum).Add(slice),
+// which is admitted if it is not after now.
+func Floor(mark, now time.Time, quantum time.Duration) time.Time {
+	if t := now.Add(-quantum); !mark.After(t) {
 		return t
 	}
 	return mark
 }
 
-type ask struct {
-	string
-	time.Duration
+// now returns the clock's time, but never earlier than a time it has returned before.
+// After a clock jumps backward, time stands still until the clock catches up, instead
+// of replaying the interval it jumped over and granting its quantum twice. With a
+// granularity, the time is snapped to a boundary. It must be called on the run goroutine.
+func (l *keyed[K]) now() time.Time {
+	if now := l.clock.Now(); now.After(l.last) {
+		l.last = now
+	}
+	return l.snap(l.last)
+}
+
+type ask[K comparable] struct {
+	task  K
+	slice time.Duration
+	at    time.Time // zero means now
 	reply chan time.Duration
 }
+
+// Decision is a single scheduling decision recorded for a watched task.
+type Decision struct {
+	At       time.Time
+	Slice    time.Duration
+	Delay    time.Duration
+	Admitted bool
+}
+
+// history is a ring buffer of the last historyEntries decisions
+type history struct {
+	ring []Decision
+	next int
+}
+
+func (h *history) add(d Decision) {
+	if len(h.ring) < historyEntries {
+		h.ring = append(h.ring, d)
+		return
+	}
+	h.ring[h.next] = d
+	h.next = (h.next + 1) % len(h.ring)
+}
+
+func (h *history) list() []Decision {
+	d := make([]Decision, 0, len(h.ring))
+	d = append(d, h.ring[h.next:]...)
+	return append(d, h.ring[:h.next]...)
+}
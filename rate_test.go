@@ -1,12 +1,49 @@
 package rate
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	sync.Mutex
+	now  time.Time
+	tick chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1e9, 0), tick: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Tick delivers a tick to the limiter's run goroutine
+func (c *fakeClock) Tick() {
+	c.tick <- c.Now()
+}
+
 func TestLimiterBasic(t *testing.T) {
 	l := New(time.Second * 30)
 	defer l.Close()
@@ -24,29 +61,176 @@ func TestLimiterBasic(t *testing.T) {
 	}
 }
 
+func TestNewChecked(t *testing.T) {
+	for _, q := range []time.Duration{0, -time.Second} {
+		if _, err := NewChecked(q); err != ErrInvalidQuantum {
+			t.Fatalf("quantum %s: bad error: want %v, have %v", q, ErrInvalidQuantum, err)
+		}
+	}
+	l, err := NewChecked(time.Second)
+	if err != nil {
+		t.Fatalf("quantum 1s: %v", err)
+	}
+	l.Close()
+
+	defer func() {
+		if recover() != ErrInvalidQuantum {
+			t.Fatalf("New did not panic with ErrInvalidQuantum")
+		}
+	}()
+	New(0)
+}
+
 func TestLimiterSchedule(t *testing.T) {
-	l := New(time.Second * 2)
+	l := NewWithClock(time.Second*2, newFakeClock())
 	defer l.Close()
 	Allow(l, "a")
 	Allow(l, "a")
 	delay := l.Schedule("a", time.Second)
-	if delay.Truncate(time.Second) != time.Second {
-		t.Fatalf("bad delay: want ~1s, have %s", delay)
+	if delay != time.Second {
+		t.Fatalf("bad delay: want 1s, have %s", delay)
 	}
 }
 
 func TestLimiterSlice(t *testing.T) {
-	l := New(time.Second * 2)
+	l := NewWithClock(time.Second*2, newFakeClock())
 	defer l.Close()
 	AllowSlice(l, "a", time.Second*2)
 	delay := l.Schedule("a", time.Second)
-	if delay.Truncate(time.Second) != time.Second {
-		t.Fatalf("bad delay: want ~1s, have %s", delay)
+	if delay != time.Second {
+		t.Fatalf("bad delay: want 1s, have %s", delay)
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	l := NewWithClock(time.Second*5, newFakeClock())
+	defer l.Close()
+	if !AllowN(l, "a", 3) {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if AllowN(l, "a", 3) {
+		t.Fatalf("2/3: have allow, want deny")
+	}
+	if !AllowN(l, "a", 2) || Allow(l, "a") {
+		t.Fatalf("3/3: denied request consumed quantum")
+	}
+}
+
+func TestLimiterScheduleAt(t *testing.T) {
+	l := New(time.Second * 2)
+	defer l.Close()
+	t0 := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	timeline := []struct {
+		at    time.Duration
+		delay time.Duration
+	}{
+		{0, 0},
+		{0, 0},
+		{0, time.Second},
+		{time.Second / 2, time.Second / 2},
+		{time.Second, 0},
+		{time.Second * 10, 0},
+	}
+	for i, e := range timeline {
+		if delay := max(l.ScheduleAt("a", time.Second, t0.Add(e.at)), 0); delay != e.delay {
+			t.Fatalf("%d: bad delay at %s: want %s, have %s", i, e.at, e.delay, delay)
+		}
+	}
+}
+
+func TestLimiterJitter(t *testing.T) {
+	delays := func(seed int64) (d []time.Duration) {
+		l := NewWithOptions(time.Second, Options{
+			Clock:  newFakeClock(),
+			Jitter: time.Second,
+			Rand:   rand.New(rand.NewSource(seed)),
+		})
+		defer l.Close()
+		if delay := l.Schedule("a", time.Second); delay > 0 {
+			t.Fatalf("jitter must not affect admission: delay %s", delay)
+		}
+		for i := 0; i < 20; i++ {
+			d = append(d, l.Schedule("a", time.Second/2))
+		}
+		return d
+	}
+	d := delays(1)
+	for _, delay := range d {
+		if delay < time.Second/2 || delay > time.Second*3/2 {
+			t.Fatalf("delay out of range: want [500ms, 1.5s], have %s", delay)
+		}
+	}
+	if fmt.Sprint(d) != fmt.Sprint(delays(1)) {
+		t.Fatalf("seeded jitter is not deterministic")
+	}
+}
+
+func TestLimiterScheduleInfo(t *testing.T) {
+	l := NewWithClock(time.Second*3, newFakeClock())
+	defer l.Close()
+	if i := l.ScheduleInfo("a", time.Second*2); i.Delay > 0 || i.Remaining != time.Second {
+		t.Fatalf("1/2: want admit with 1s remaining, have delay %s remaining %s", i.Delay, i.Remaining)
+	}
+	if i := l.ScheduleInfo("a", time.Second*2); i.Delay != time.Second || i.Remaining != time.Second {
+		t.Fatalf("2/2: want 1s delay with 1s remaining, have delay %s remaining %s", i.Delay, i.Remaining)
+	}
+}
+
+func TestLimiterNearLimit(t *testing.T) {
+	l := NewWithOptions(time.Second*10, Options{Clock: newFakeClock(), NearLimit: 0.8})
+	defer l.Close()
+	for i, want := range []bool{false, false, false, false, false, false, false, true, true, true, true} {
+		if info := l.ScheduleInfo("a", time.Second); info.NearLimit != want {
+			t.Fatalf("%d: bad near limit: want %v, have %+v", i, want, info)
+		}
+	}
+}
+
+func TestLimiterScheduleBatch(t *testing.T) {
+	l := NewWithClock(time.Second*2, newFakeClock())
+	defer l.Close()
+	delays := l.ScheduleBatch([]Request{
+		{"a", time.Second},
+		{"b", time.Second * 3},
+		{"a", time.Second},
+		{"a", time.Second},
+	})
+	if len(delays) != 4 {
+		t.Fatalf("bad delay count: want 4, have %d", len(delays))
+	}
+	for i, admit := range []bool{true, false, true, false} {
+		if (delays[i] <= 0) != admit {
+			t.Fatalf("request %d: bad decision: want admit=%v, have delay %s", i, admit, delays[i])
+		}
+	}
+	l.Close()
+	if delays := l.ScheduleBatch([]Request{{"a", 0}}); delays[0] != Forever {
+		t.Fatalf("bad delay after close: want Forever, have %s", delays[0])
+	}
+}
+
+func TestLimiterScheduleAll(t *testing.T) {
+	l := NewWithClock(time.Second*2, newFakeClock())
+	defer l.Close()
+	AllowSlice(l, "tenant", time.Second)
+	if _, ok := l.ScheduleAll([]Request{{"user", time.Second}, {"tenant", time.Second}}); !ok {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	delay, ok := l.ScheduleAll([]Request{{"user", time.Second}, {"tenant", time.Second}})
+	if ok || delay != time.Second {
+		t.Fatalf("2/3: want deny with 1s delay, have ok=%v delay=%s", ok, delay)
+	}
+	if r := l.Remaining("user"); r != time.Second {
+		t.Fatalf("denied batch consumed quantum: want 1s remaining, have %s", r)
+	}
+	if _, ok := l.ScheduleAll([]Request{{"user", time.Second}, {"user", time.Second}}); ok {
+		t.Fatalf("3/3: repeated task exceeded its quantum")
 	}
 }
 
 func TestLimiterReplenish(t *testing.T) {
-	l := New(time.Second * 3)
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*3, clock)
 	defer l.Close()
 	for i := 0; i < 7; i++ {
 		Allow(l, "bar")
@@ -54,7 +238,7 @@ func TestLimiterReplenish(t *testing.T) {
 	if Allow(l, "bar") {
 		t.Fatalf("1/3: have allow, want deny")
 	}
-	time.Sleep(time.Second)
+	clock.Advance(time.Second)
 	if !Allow(l, "bar") {
 		t.Fatalf("2/3: have deny, want allow")
 	}
@@ -64,13 +248,8 @@ func TestLimiterReplenish(t *testing.T) {
 }
 
 func TestLimiterSweepl(t *testing.T) {
-	x := tickInterval
-	tickInterval = time.Second * 1
-	defer func() {
-		tickInterval = x
-	}()
 	tm := time.NewTimer(time.Second * 2)
-	l := New(time.Millisecond)
+	l := NewWithOptions(time.Millisecond, Options{SweepInterval: time.Second})
 	defer l.Close()
 	n := 0
 	defer func() { t.Logf("accepted %d requests", n) }()
@@ -85,6 +264,90 @@ func TestLimiterSweepl(t *testing.T) {
 	}
 }
 
+func TestLimiterSweepAll(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: SweepAll})
+	defer l.Close()
+	for i := 0; i < 10000; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	clock.Advance(time.Second * 2)
+	clock.Tick()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("bad task count after sweep: want 0, have %d", n)
+	}
+}
+
+func TestLimiterMaxSweep(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: 3})
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	clock.Advance(time.Second * 2)
+	clock.Tick()
+	if n := l.Len(); n != 7 {
+		t.Fatalf("bad task count after sweep: want 7, have %d", n)
+	}
+}
+
+func TestLimiterMaxSweepInvalid(t *testing.T) {
+	defer func() {
+		if recover() != errMaxSweep {
+			t.Fatalf("MaxSweep -2 did not panic with %v", errMaxSweep)
+		}
+	}()
+	NewWithOptions(time.Second, Options{MaxSweep: -2})
+}
+
+func TestLimiterSweepProgress(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: 10})
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	clock.Advance(time.Second * 2)
+	Allow(l, "fresh")
+
+	// 101 tasks take 101/10+1 sweeps
+	for i := 0; i < 11; i++ {
+		clock.Tick()
+	}
+	if n := l.Len(); n != 1 {
+		t.Fatalf("bad task count after 11 sweeps: want 1, have %d", n)
+	}
+}
+
+func TestLimiterSweepMonotonic(t *testing.T) {
+	l := NewWithOptions(time.Second, Options{MaxSweep: SweepAll})
+	defer l.Close()
+	now := time.Now()
+	offsets := []time.Duration{-time.Second - 1, -time.Second, -time.Second + 1, 0}
+	l.do(func() {
+		for i, d := range offsets {
+			mark := now.Add(d)
+			l.set(fmt.Sprint("mono", i), mark)
+			l.set(fmt.Sprint("wall", i), mark.Round(0))
+		}
+		l.sweepTasks(now)
+	})
+	for i := range offsets {
+		mono, wall := false, false
+		l.do(func() {
+			_, mono = l.marks[fmt.Sprint("mono", i)]
+			_, wall = l.marks[fmt.Sprint("wall", i)]
+		})
+		if mono != wall {
+			t.Fatalf("offset %s: inconsistent sweep: monotonic kept=%v, wall kept=%v", offsets[i], mono, wall)
+		}
+		if want := offsets[i] > -time.Second; mono != want {
+			t.Fatalf("offset %s: bad sweep: want kept=%v, have %v", offsets[i], want, mono)
+		}
+	}
+}
+
 func TestLimiterMulti(t *testing.T) {
 	l := New(time.Second * 30)
 	defer l.Close()
@@ -103,9 +366,881 @@ func TestLimiterMulti(t *testing.T) {
 	}
 }
 
+func TestLimiterPeek(t *testing.T) {
+	l := NewWithClock(time.Second*2, newFakeClock())
+	defer l.Close()
+	Allow(l, "a")
+	for i := 0; i < 3; i++ {
+		if delay := l.Peek("a", time.Second); delay > 0 {
+			t.Fatalf("peek %d: bad delay: want <= 0, have %s", i, delay)
+		}
+		if delay := l.Peek("a", time.Second*2); delay != time.Second {
+			t.Fatalf("peek %d: bad delay: want 1s, have %s", i, delay)
+		}
+	}
+	if !Allow(l, "a") || Allow(l, "a") {
+		t.Fatalf("peek changed admission outcomes")
+	}
+}
+
+func TestLimiterReset(t *testing.T) {
+	l := New(time.Second * 2)
+	defer l.Close()
+	AllowSlice(l, "a", time.Second*2)
+	if Allow(l, "a") {
+		t.Fatalf("1/2: have allow, want deny")
+	}
+	l.Reset("a")
+	l.Reset("unknown")
+	if !AllowSlice(l, "a", time.Second*2) {
+		t.Fatalf("2/2: have deny, want allow")
+	}
+}
+
+func TestLimiterHas(t *testing.T) {
+	l := New(time.Second * 2)
+	defer l.Close()
+	if l.Has("a") {
+		t.Fatalf("unseen task is tracked")
+	}
+	Allow(l, "a")
+	if !l.Has("a") {
+		t.Fatalf("scheduled task is not tracked")
+	}
+	l.Reset("a")
+	if l.Has("a") {
+		t.Fatalf("reset task is tracked")
+	}
+}
+
+func TestLimiterResetMatching(t *testing.T) {
+	evicted := make(chan string, 4)
+	l := NewWithOptions(time.Second, Options{OnEvict: func(task string) { evicted <- task }})
+	defer l.Close()
+	for _, task := range []string{"a1", "a2", "b1", "b2"} {
+		Allow(l, task)
+	}
+	l.ResetMatching(func(task string) bool { return strings.HasPrefix(task, "a") })
+	tasks := l.Tasks()
+	sort.Strings(tasks)
+	if fmt.Sprint(tasks) != "[b1 b2]" {
+		t.Fatalf("bad tasks after ResetMatching: want [b1 b2], have %v", tasks)
+	}
+	l.ResetAll()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("bad task count after ResetAll: want 0, have %d", n)
+	}
+	var have []string
+	for range 4 {
+		have = append(have, <-evicted)
+	}
+	sort.Strings(have)
+	if fmt.Sprint(have) != "[a1 a2 b1 b2]" {
+		t.Fatalf("bad evictions: want [a1 a2 b1 b2], have %v", have)
+	}
+}
+
+func TestLimiterRemaining(t *testing.T) {
+	l := New(time.Second * 4)
+	defer l.Close()
+	if r := l.Remaining("a"); r != time.Second*4 {
+		t.Fatalf("bad remaining for unseen task: want 4s, have %s", r)
+	}
+	Allow(l, "a")
+	if r := l.Remaining("a").Round(time.Second); r != time.Second*3 {
+		t.Fatalf("bad remaining: want ~3s, have %s", r)
+	}
+	if r := l.Remaining("a").Round(time.Second); r != time.Second*3 {
+		t.Fatalf("remaining consumed quantum: want ~3s, have %s", r)
+	}
+}
+
+func TestLimiterLen(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("bad length: want 0, have %d", n)
+	}
+	Allow(l, "a")
+	Allow(l, "a")
+	Allow(l, "b")
+	if n := l.Len(); n != 2 {
+		t.Fatalf("bad length: want 2, have %d", n)
+	}
+	if l.Cap() != preallocEntries {
+		t.Fatalf("bad capacity: want %d, have %d", preallocEntries, l.Cap())
+	}
+}
+
+func TestLimiterTasks(t *testing.T) {
+	l := NewWithClock(time.Second*4, newFakeClock())
+	defer l.Close()
+	Allow(l, "a")
+	AllowSlice(l, "b", time.Second*3)
+	tasks := l.Tasks()
+	sort.Strings(tasks)
+	if fmt.Sprint(tasks) != "[a b]" {
+		t.Fatalf("bad tasks: want [a b], have %v", tasks)
+	}
+	seen := map[string]time.Duration{}
+	l.TasksFunc(func(task string, remaining time.Duration) bool {
+		seen[task] = remaining
+		return true
+	})
+	if seen["a"] != time.Second*3 || seen["b"] != time.Second {
+		t.Fatalf("bad remaining quantum: %v", seen)
+	}
+	n := 0
+	l.TasksFunc(func(string, time.Duration) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("visitor did not stop: want 1 call, have %d", n)
+	}
+}
+
+func TestLimiterSetQuantum(t *testing.T) {
+	l := NewWithClock(time.Second*2, newFakeClock())
+	defer l.Close()
+	AllowSlice(l, "a", time.Second*2)
+	if Allow(l, "a") {
+		t.Fatalf("1/3: have allow, want deny")
+	}
+	l.SetQuantum(time.Second * 3)
+	if l.Quantum() != time.Second*3 {
+		t.Fatalf("wrong quantum: want 3s, have %s", l.Quantum())
+	}
+	if !AllowSlice(l, "b", time.Second*3) {
+		t.Fatalf("2/3: have deny, want allow")
+	}
+	l.SetQuantum(time.Second)
+	if r := l.Remaining("c"); r != time.Second {
+		t.Fatalf("3/3: bad remaining: want 1s, have %s", r)
+	}
+}
+
+func TestLimiterSetQuantumInvalid(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	for _, q := range []time.Duration{0, -time.Second} {
+		func() {
+			defer func() {
+				if recover() != ErrInvalidQuantum {
+					t.Errorf("quantum %s: SetQuantum did not panic with ErrInvalidQuantum", q)
+				}
+			}()
+			l.SetQuantum(q)
+		}()
+	}
+	if l.Quantum() != time.Second {
+		t.Fatalf("invalid quantum was applied: have %s", l.Quantum())
+	}
+}
+
+func TestLimiterStats(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*2, Options{Clock: clock, MaxSweep: SweepAll})
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		Allow(l, "a")
+	}
+	Allow(l, "b")
+	clock.Advance(time.Second * 3)
+	clock.Tick()
+	l.Len() // wait for the sweep
+	if s := l.Stats(); s != (Stats{Accepted: 3, Denied: 1, Evicted: 2}) {
+		t.Fatalf("bad stats: %+v", s)
+	}
+	l.ResetStats()
+	if s := l.Stats(); s != (Stats{}) {
+		t.Fatalf("bad stats after reset: %+v", s)
+	}
+}
+
+func TestLimiterClose(t *testing.T) {
+	l := New(time.Second)
+	Allow(l, "a")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Close()
+			select {
+			case <-l.done:
+			default:
+				t.Errorf("Close returned before the limiter was closed")
+			}
+			if delay := l.Schedule("a", time.Second); delay != Forever {
+				t.Errorf("bad delay after close: want Forever, have %s", delay)
+			}
+			l.Len()
+			l.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLimiterScheduleAfterClose(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		l := New(time.Second)
+		l.Close()
+		if delay := l.Schedule("a", time.Millisecond); delay != Forever {
+			t.Fatalf("%d: bad delay after close: want Forever, have %s", i, delay)
+		}
+		if delay, err := l.ScheduleErr("a", time.Millisecond); delay != Forever || err != ErrClosed {
+			t.Fatalf("%d: have delay %s and error %v, want Forever and %v", i, delay, err, ErrClosed)
+		}
+		l.SetWeight("a", 2)
+		if n := l.Len(); n != 0 {
+			t.Fatalf("%d: tasks changed after close: have %d", i, n)
+		}
+	}
+}
+
+func TestLimiterWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := NewWithContext(ctx, time.Second)
+	if !Allow(l, "a") {
+		t.Fatalf("before cancel: have deny, want allow")
+	}
+	cancel()
+	<-l.exited
+	if delay := l.Schedule("b", time.Second); delay != Forever {
+		t.Fatalf("bad delay after cancel: want Forever, have %s", delay)
+	}
+	l.Close()
+}
+
+func TestLimiterSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*2, clock)
+	defer l.Close()
+	AllowSlice(l, "a", time.Second*2)
+	Allow(l, "b")
+	snap := l.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("bad snapshot size: want 2, have %d", len(snap))
+	}
+
+	l2 := NewWithClock(time.Second*2, clock)
+	defer l2.Close()
+	l2.Restore(snap)
+	if Allow(l2, "a") {
+		t.Fatalf("restored task a: have allow, want deny")
+	}
+	if !Allow(l2, "b") || Allow(l2, "b") {
+		t.Fatalf("restored task b: want exactly one more admission")
+	}
+}
+
+func TestLimiterMaxTasks(t *testing.T) {
+	l := NewWithOptions(time.Second*30, Options{Clock: newFakeClock(), MaxTasks: 3})
+	defer l.Close()
+	for _, task := range []string{"a", "b", "c", "a", "d"} {
+		Allow(l, task)
+	}
+	tasks := l.Tasks()
+	sort.Strings(tasks)
+	if fmt.Sprint(tasks) != "[a c d]" {
+		t.Fatalf("bad tasks: want [a c d], have %v", tasks)
+	}
+	if s := l.Stats(); s.Displaced != 1 {
+		t.Fatalf("bad displaced count: want 1, have %d", s.Displaced)
+	}
+	for i := 0; i < 1000; i++ {
+		Allow(l, fmt.Sprint(i))
+	}
+	if n := l.Len(); n != 3 {
+		t.Fatalf("bad task count: want 3, have %d", n)
+	}
+}
+
+func TestLimiterOnEvict(t *testing.T) {
+	clock := newFakeClock()
+	evicted := make(chan string, 10)
+	var l *limiter
+	l = NewWithOptions(time.Second, Options{
+		Clock:    clock,
+		MaxSweep: SweepAll,
+		MaxTasks: 2,
+		OnEvict: func(task string) {
+			l.Len() // must not deadlock
+			evicted <- task
+		},
+	})
+	defer l.Close()
+	Allow(l, "a")
+	Allow(l, "b")
+	Allow(l, "c")
+	if task := <-evicted; task != "a" {
+		t.Fatalf("bad eviction: want a, have %s", task)
+	}
+	l.Reset("b")
+	if task := <-evicted; task != "b" {
+		t.Fatalf("bad eviction: want b, have %s", task)
+	}
+	clock.Advance(time.Second * 2)
+	clock.Tick()
+	if task := <-evicted; task != "c" {
+		t.Fatalf("bad eviction: want c, have %s", task)
+	}
+}
+
+func TestLimiterKeyed(t *testing.T) {
+	type key struct {
+		user  int
+		route string
+	}
+	l := NewKeyedWithOptions(time.Second*2, KeyedOptions[key]{Clock: newFakeClock()})
+	defer l.Close()
+	a, b := key{1, "/a"}, key{1, "/b"}
+	l.Schedule(a, time.Second*2)
+	if delay := l.Schedule(a, time.Second); delay != time.Second {
+		t.Fatalf("bad delay: want 1s, have %s", delay)
+	}
+	if delay := l.Schedule(b, time.Second*2); delay > 0 {
+		t.Fatalf("distinct key denied: delay %s", delay)
+	}
+	if tasks := l.Tasks(); len(tasks) != 2 {
+		t.Fatalf("bad tasks: %v", tasks)
+	}
+}
+
+func TestLimiterCloseContext(t *testing.T) {
+	l := New(time.Second * 30)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Allow(l, "a")
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if dropped, err := l.CloseContext(context.Background()); dropped != 0 || err != nil {
+		t.Fatalf("bad close: dropped %d, err %v", dropped, err)
+	}
+	wg.Wait()
+	if delay := l.Schedule("a", time.Second); delay != Forever {
+		t.Fatalf("bad delay after close: want Forever, have %s", delay)
+	}
+	if s := l.Stats(); s.Accepted+s.Denied == 0 {
+		t.Fatalf("no queued schedules were answered")
+	}
+
+	// Block the run goroutine so a queued schedule cannot be answered
+	l = New(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var dropped int
+	var err error
+	l.do(func() {
+		go l.Schedule("a", time.Second)
+		for len(l.schedule) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		dropped, err = l.CloseContext(ctx)
+	})
+	if dropped != 1 || err != context.Canceled {
+		t.Fatalf("bad close: dropped %d, err %v", dropped, err)
+	}
+}
+
+func TestLimiterErr(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	if err := l.Err(); err != nil {
+		t.Fatalf("healthy limiter: have error %v", err)
+	}
+	Allow(l, "a")
+	l.TasksFunc(func(string, time.Duration) bool { panic("boom") })
+	if err := l.Err(); err == nil {
+		t.Fatalf("crashed limiter: have nil error")
+	}
+	if delay := l.Schedule("a", time.Second); delay != Forever {
+		t.Fatalf("bad delay after crash: want Forever, have %s", delay)
+	}
+	l.Len()
+}
+
+func TestLimiterScheduleCtx(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second, clock)
+	defer l.Close()
+	if delay, err := l.ScheduleCtx(context.Background(), "a", time.Second); delay > 0 || err != nil {
+		t.Fatalf("1/2: have delay %s and error %v, want admit", delay, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started, block := make(chan bool), make(chan bool)
+	go l.do(func() {
+		started <- true
+		<-block
+	})
+	<-started
+	defer close(block)
+	go l.Schedule("b", 0) // fills the schedule channel while the run goroutine is blocked
+	for len(l.schedule) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if delay, err := l.ScheduleCtx(ctx, "a", time.Second); delay != Forever || err != context.Canceled {
+		t.Fatalf("2/2: have delay %s and error %v, want Forever and %v", delay, err, context.Canceled)
+	}
+}
+
+func TestFloor(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	for _, tc := range []struct {
+		mark, want time.Time
+	}{
+		{time.Time{}, now.Add(-time.Second * 3)},
+		{now.Add(-time.Second * 5), now.Add(-time.Second * 3)},
+		{now.Add(-time.Second * 3), now.Add(-time.Second * 3)},
+		{now.Add(-time.Second), now.Add(-time.Second)},
+		{now.Add(time.Second), now.Add(time.Second)},
+	} {
+		if have := Floor(tc.mark, now, time.Second*3); !have.Equal(tc.want) {
+			t.Fatalf("Floor(%s): want %s, have %s", tc.mark, tc.want, have)
+		}
+	}
+}
+
+func TestAllowAt(t *testing.T) {
+	l := New(time.Second * 2)
+	defer l.Close()
+	at := time.Unix(1e9, 0)
+	for i, tc := range []struct {
+		offset, slice time.Duration
+		want          bool
+	}{
+		{0, time.Second, true},
+		{0, time.Second, true},
+		{0, time.Second, false},
+		{time.Second / 2, time.Second, false},
+		{time.Second, time.Second, true},
+		{time.Second * 3, time.Second * 2, true},
+	} {
+		if have := AllowSliceAt(l, "a", tc.slice, at.Add(tc.offset)); have != tc.want {
+			t.Fatalf("%d: want %v, have %v", i, tc.want, have)
+		}
+	}
+	if AllowAt(l, "a", at.Add(time.Second*3)) {
+		t.Fatalf("AllowAt: have allow, want deny")
+	}
+}
+
+func TestLimiterExceedsQuantum(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*2, Options{Clock: clock, Jitter: time.Second})
+	defer l.Close()
+	if delay := l.Schedule("a", time.Second*3); delay != Forever {
+		t.Fatalf("oversized slice: want Forever, have %s", delay)
+	}
+	if delay := l.Peek("a", time.Second*3); delay != Forever {
+		t.Fatalf("oversized peek: want Forever, have %s", delay)
+	}
+	l.SetWeight("b", 3)
+	if delay := l.Schedule("b", time.Second); delay != Forever {
+		t.Fatalf("oversized weighted slice: want Forever, have %s", delay)
+	}
+	if !AllowSlice(l, "a", time.Second*2) {
+		t.Fatalf("slice equal to the quantum: have deny, want allow")
+	}
+}
+
+func TestExceedsQuantumForever(t *testing.T) {
+	for _, l := range []Limiter{
+		NewBucket(1, 2),
+		NewFixedWindow(2, time.Minute),
+		NewGCRA(time.Second, 2),
+		NewLeaky(time.Second*2, time.Second),
+	} {
+		if delay := l.Schedule("a", time.Second*3); delay != Forever {
+			t.Errorf("%T: oversized slice: want Forever, have %s", l, delay)
+		}
+		if !AllowSlice(l, "a", time.Second*2) {
+			t.Errorf("%T: slice equal to the quantum: have deny, want allow", l)
+		}
+		l.Close()
+	}
+}
+
+func TestLimiterOnSweep(t *testing.T) {
+	clock := newFakeClock()
+	sweeps := make(chan SweepStats, 1)
+	l := NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: SweepAll, OnSweep: func(s SweepStats) {
+		sweeps <- s
+	}})
+	defer l.Close()
+	Allow(l, "a")
+	Allow(l, "b")
+	clock.Advance(time.Second)
+	Allow(l, "c")
+	clock.Tick()
+	if s := <-sweeps; s.Examined != 3 || s.Deleted != 2 || s.MapSize != 1 || s.Duration < 0 {
+		t.Fatalf("bad sweep: %+v", s)
+	}
+}
+
+func TestLimiterScheduleErr(t *testing.T) {
+	l := NewWithClock(time.Second, newFakeClock())
+	if delay, err := l.ScheduleErr("a", time.Second); delay > 0 || err != nil {
+		t.Fatalf("open: have delay %s and error %v, want admit", delay, err)
+	}
+	if delay, err := l.ScheduleErr("a", time.Second*2); delay != Forever || err != ErrExceedsQuantum {
+		t.Fatalf("oversized: have delay %s and error %v, want Forever and %v", delay, err, ErrExceedsQuantum)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task := fmt.Sprint(i)
+			for j := 0; j < 100; j++ {
+				l.ScheduleErr(task, time.Millisecond)
+			}
+		}(i)
+	}
+	l.Close()
+	wg.Wait()
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task := fmt.Sprint(i)
+			if delay, err := l.ScheduleErr(task, 0); delay != Forever || err != ErrClosed {
+				t.Errorf("closed: have delay %s and error %v, want Forever and %v", delay, err, ErrClosed)
+			}
+			if delay := l.Schedule(task, 0); delay != Forever {
+				t.Errorf("closed: have delay %s, want Forever", delay)
+			}
+			if err := Wait(l, task, 0); err != ErrClosed {
+				t.Errorf("closed wait: have %v, want %v", err, ErrClosed)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLimiterRateEstimate(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Minute, Options{Clock: clock, RateHalfLife: time.Second * 3})
+	defer l.Close()
+	for i := 0; i < 12; i++ {
+		Allow(l, "a")
+	}
+	clock.Advance(time.Second * 3)
+	clock.Tick()
+	l.Len()
+	if r := l.RateEstimate(); r != 2 {
+		t.Fatalf("1/2: bad estimate: want 2/s, have %v/s", r)
+	}
+	clock.Advance(time.Second * 3)
+	clock.Tick()
+	l.Len()
+	if r := l.RateEstimate(); r != 1 {
+		t.Fatalf("2/2: bad estimate: want 1/s, have %v/s", r)
+	}
+}
+
+func TestLimiterEntryTTL(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*10, Options{Clock: clock, MaxSweep: SweepAll, EntryTTL: time.Second})
+	Allow(l, "a")
+	clock.Advance(time.Second)
+	clock.Tick()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("short ttl: task kept past its ttl: want 0 tasks, have %d", n)
+	}
+	l.Close()
+
+	clock = newFakeClock()
+	l = NewWithOptions(time.Second, Options{Clock: clock, MaxSweep: SweepAll, EntryTTL: time.Second * 5})
+	defer l.Close()
+	Allow(l, "a")
+	clock.Advance(time.Second * 2)
+	clock.Tick()
+	if n := l.Len(); n != 1 {
+		t.Fatalf("long ttl: task swept with its quantum: want 1 task, have %d", n)
+	}
+	Allow(l, "a")
+	clock.Advance(time.Second * 4)
+	clock.Tick()
+	if n := l.Len(); n != 1 {
+		t.Fatalf("long ttl: schedule did not refresh the task: want 1 task, have %d", n)
+	}
+	clock.Advance(time.Second)
+	clock.Tick()
+	if n := l.Len(); n != 0 {
+		t.Fatalf("long ttl: task kept past its ttl: want 0 tasks, have %d", n)
+	}
+}
+
+func TestLimiterEntryTTLDenied(t *testing.T) {
+	l := NewWithOptions(time.Second, Options{EntryTTL: time.Second})
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		l.Schedule(fmt.Sprint(i), time.Second*2)
+	}
+	var n int
+	l.do(func() { n = len(l.lastSeen) })
+	if n != 0 || l.Len() != 0 {
+		t.Fatalf("denied untracked tasks were kept: %d lastSeen entries and %d tasks", n, l.Len())
+	}
+}
+
+func TestLimiterFair(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*10, Options{Clock: clock, Fair: true})
+	defer l.Close()
+	Allow(l, "b")
+	Allow(l, "a")
+	Allow(l, "a")
+
+	var have []string
+	l.do(func() {
+		asks := []ask[string]{{task: "a"}, {task: "c"}, {task: "b"}}
+		l.sortFair(asks)
+		for _, a := range asks {
+			have = append(have, a.task)
+		}
+	})
+	if fmt.Sprint(have) != "[c b a]" {
+		t.Fatalf("bad fair order: want [c b a], have %v", have)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Allow(l, fmt.Sprint(i%4))
+		}(i)
+	}
+	wg.Wait()
+	if s := l.Stats(); s.Accepted != 23 {
+		t.Fatalf("bad accepted count: want 23, have %d", s.Accepted)
+	}
+}
+
+func TestLimiterWeight(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*2, Options{Clock: clock, MaxSweep: SweepAll})
+	defer l.Close()
+	l.SetWeight("cheap", 0.5)
+	l.SetWeight("dear", 2)
+	n := 0
+	for ; n < 100 && Allow(l, "cheap"); n++ {
+	}
+	if n != 4 {
+		t.Fatalf("bad request count at weight 0.5: want 4, have %d", n)
+	}
+	clock.Advance(time.Second / 2)
+	if !Allow(l, "cheap") || Allow(l, "cheap") {
+		t.Fatalf("bad replenishment at weight 0.5: want one request per 500ms")
+	}
+	if !Allow(l, "dear") || Allow(l, "dear") {
+		t.Fatalf("bad request count at weight 2: want 1")
+	}
+	if delay := l.Schedule("dear", time.Second); delay != time.Second*2 {
+		t.Fatalf("bad delay at weight 2: want 2s, have %s", delay)
+	}
+
+	clock.Advance(time.Second * 3)
+	clock.Tick()
+	if !AllowSlice(l, "dear", time.Second*2) {
+		t.Fatalf("weight survived the sweep")
+	}
+}
+
+func TestLimiterWeightInvalid(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	for _, w := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("weight %v: SetWeight did not panic", w)
+				}
+			}()
+			l.SetWeight("a", w)
+		}()
+	}
+	if !Allow(l, "a") || Allow(l, "a") {
+		t.Fatalf("invalid weight was applied")
+	}
+}
+
+func TestLimiterHistory(t *testing.T) {
+	l := New(time.Second * 2)
+	defer l.Close()
+	Allow(l, "a")
+	if h := l.History("a"); h != nil {
+		t.Fatalf("unwatched task has history: %v", h)
+	}
+	l.Watch("a")
+	Allow(l, "a")
+	Allow(l, "a")
+	Allow(l, "b")
+	h := l.History("a")
+	if len(h) != 2 {
+		t.Fatalf("bad history length: want 2, have %d", len(h))
+	}
+	if !h[0].Admitted || h[1].Admitted {
+		t.Fatalf("bad decisions: want admit then deny, have %v", h)
+	}
+	if h[0].Slice != time.Second || h[1].Delay <= 0 {
+		t.Fatalf("bad decision fields: %v", h)
+	}
+	l.Unwatch("a")
+	if h := l.History("a"); h != nil {
+		t.Fatalf("unwatched task has history: %v", h)
+	}
+}
+
+func TestLimiterHistoryRing(t *testing.T) {
+	l := New(time.Hour)
+	defer l.Close()
+	l.Watch("a")
+	for i := 1; i <= historyEntries+5; i++ {
+		AllowSlice(l, "a", time.Duration(i))
+	}
+	h := l.History("a")
+	if len(h) != historyEntries {
+		t.Fatalf("bad history length: want %d, have %d", historyEntries, len(h))
+	}
+	if h[0].Slice != 6 || h[len(h)-1].Slice != time.Duration(historyEntries+5) {
+		t.Fatalf("history out of order: first %d, last %d", h[0].Slice, h[len(h)-1].Slice)
+	}
+}
+
+func TestLimiterWaitContext(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	ctx := context.Background()
+	AllowSlice(l, "a", time.Second)
+	t0 := time.Now()
+	if err := l.WaitContext(ctx, "a", time.Second/4); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if dt := time.Since(t0); dt < time.Second/8 {
+		t.Fatalf("wait returned too early: %s", dt)
+	}
+	if err := l.WaitContext(ctx, "a", time.Second*2); err != ErrExceedsQuantum {
+		t.Fatalf("bad error: want %v, have %v", ErrExceedsQuantum, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second/10)
+	defer cancel()
+	AllowSlice(l, "b", time.Second)
+	if err := l.WaitContext(ctx, "b", time.Second); err != context.DeadlineExceeded {
+		t.Fatalf("bad error: want %v, have %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := New(time.Second)
+	defer l.Close()
+	AllowSlice(l, "a", time.Second)
+	if err := Wait(l, "a", time.Second/4); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if Allow(l, "a") {
+		t.Fatalf("wait did not consume its slice")
+	}
+	if err := Wait(l, "a", time.Second*2); err != ErrExceedsQuantum {
+		t.Fatalf("bad error: want %v, have %v", ErrExceedsQuantum, err)
+	}
+	if err := WaitFor(l, "a", time.Second, time.Second/10); err != context.DeadlineExceeded {
+		t.Fatalf("bad error: want %v, have %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestLimiterAllocs(t *testing.T) {
+	l := New(time.Second * 30)
+	defer l.Close()
+	Allow(l, "a")
+	if n := testing.AllocsPerRun(100, func() { Allow(l, "a") }); n != 0 {
+		t.Fatalf("bad allocation count: want 0, have %v", n)
+	}
+}
+
 func BenchmarkLimiter(b *testing.B) {
 	l := New(time.Second * 30)
 	defer l.Close()
+	b.ReportAllocs()
+	body := func(pb *testing.PB) {
+		name := fmt.Sprint(rand.Int31n(7))
+		for pb.Next() {
+			Allow(l, name)
+		}
+	}
+	b.RunParallel(body)
+}
+
+func BenchmarkDecide(b *testing.B) {
+	l := New(time.Second * 30)
+	defer l.Close()
+	names := make([]string, 7)
+	for i := range names {
+		names[i] = fmt.Sprint(i)
+	}
+	b.ReportAllocs()
+	l.do(func() {
+		now := time.Now()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.decide(names[i%len(names)], time.Nanosecond, now)
+		}
+	})
+}
+
+// BenchmarkDecideChurn is like BenchmarkDecide, but every decision is for a task that is
+// not tracked, as under a flood of distinct tasks
+func BenchmarkDecideChurn(b *testing.B) {
+	l := New(time.Second * 30)
+	defer l.Close()
+	names := make([]string, 1<<16)
+	for i := range names {
+		names[i] = fmt.Sprint(i)
+	}
+	b.ReportAllocs()
+	l.do(func() {
+		now := time.Now()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%len(names) == 0 {
+				clear(l.marks)
+			}
+			l.decide(names[i%len(names)], time.Nanosecond, now)
+		}
+	})
+}
+
+func BenchmarkLimiterBuffer(b *testing.B) {
+	for _, n := range []int{1, 16, 256} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			l := NewWithOptions(time.Second*30, Options{ScheduleBuffer: n})
+			defer l.Close()
+			b.ReportAllocs()
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					Allow(l, fmt.Sprint(rand.Int31n(1000)))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSharded(b *testing.B) {
+	l := NewSharded(time.Second*30, 8)
+	defer l.Close()
+	b.ReportAllocs()
 	body := func(pb *testing.PB) {
 		name := fmt.Sprint(rand.Int31n(7))
 		for pb.Next() {
@@ -114,3 +1249,89 @@ func BenchmarkLimiter(b *testing.B) {
 	}
 	b.RunParallel(body)
 }
+
+func BenchmarkKeyed(b *testing.B) {
+	l := NewKeyed[int32](time.Second * 30)
+	defer l.Close()
+	b.ReportAllocs()
+	body := func(pb *testing.PB) {
+		key := rand.Int31n(7)
+		for pb.Next() {
+			l.Schedule(key, time.Second)
+		}
+	}
+	b.RunParallel(body)
+}
+
+func TestLimiterClockBackward(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*10, clock)
+	defer l.Close()
+	Allow(l, "a")
+	clock.Advance(-time.Second * 5)
+	n := 0
+	for i := 0; i < 20; i++ {
+		if Allow(l, "b") {
+			n++
+		}
+	}
+	clock.Advance(time.Second * 5)
+	for i := 0; i < 20; i++ {
+		if Allow(l, "b") {
+			n++
+		}
+	}
+	if n != 10 {
+		t.Fatalf("replayed quantum after the clock went backward: want 10 admissions, have %d", n)
+	}
+	clock.Advance(time.Second)
+	if !Allow(l, "b") {
+		t.Fatalf("after the clock caught up: have deny, want allow")
+	}
+}
+
+func TestLimiterBan(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*10, clock)
+	defer l.Close()
+	l.Ban("a", clock.Now().Add(time.Minute))
+	if delay := l.Schedule("a", time.Second); delay != time.Minute {
+		t.Fatalf("1/4: bad delay while banned: want 1m, have %s", delay)
+	}
+	if delay := l.Peek("a", time.Second); delay != time.Minute {
+		t.Fatalf("2/4: bad peek while banned: want 1m, have %s", delay)
+	}
+	clock.Advance(time.Minute)
+	clock.Tick()
+	l.Len()
+	if len(l.bans) != 0 {
+		t.Fatalf("3/4: expired ban was not swept")
+	}
+	if !Allow(l, "a") {
+		t.Fatalf("3/4: after ban: have deny, want allow")
+	}
+
+	l.Ban("b", clock.Now().Add(time.Hour))
+	l.Unban("b")
+	if !Allow(l, "b") {
+		t.Fatalf("4/4: after unban: have deny, want allow")
+	}
+}
+
+func TestLimiterGranularity(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithOptions(time.Second*10, Options{Clock: clock, Granularity: time.Second})
+	defer l.Close()
+	AllowSlice(l, "a", time.Second*10)
+	clock.Advance(time.Second * 3 / 2)
+	if r := l.Remaining("a"); r != time.Second {
+		t.Fatalf("1/3: bad remaining between steps: want 1s, have %s", r)
+	}
+	if delay := l.Schedule("a", time.Second*3/2); delay != time.Second {
+		t.Fatalf("2/3: bad delay: want 1s, have %s", delay)
+	}
+	clock.Advance(time.Second / 2)
+	if !AllowSlice(l, "a", time.Second*3/2) {
+		t.Fatalf("3/3: at the next step: have deny, want allow")
+	}
+}
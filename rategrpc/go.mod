@@ -0,0 +1,11 @@
+module github.com/as/rate/rategrpc
+
+go 1.22
+
+require (
+	github.com/as/rate v0.0.0
+	google.golang.org/grpc v1.67.1
+	google.golang.org/protobuf v1.35.1
+)
+
+replace github.com/as/rate => ../
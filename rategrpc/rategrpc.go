@@ -0,0 +1,56 @@
+// Package rategrpc rate-limits gRPC servers, much as httprate rate-limits http endpoints
+package rategrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/as/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// UnaryServerInterceptor returns an interceptor that schedules each call with the limiter
+// and cost under the task name returned by keyFunc, which is passed the call's context and
+// full method name. The key is typically derived from the peer address or the incoming
+// metadata. A denied call fails with codes.ResourceExhausted, and the error's details hold
+// an errdetails.RetryInfo with the delay before the call may be retried, unless the delay
+// is rate.Forever and the call may never be retried.
+func UnaryServerInterceptor(lim rate.Limiter, cost time.Duration, keyFunc func(context.Context, string) string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := schedule(lim, cost, keyFunc(ctx, info.FullMethod)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor, but for streams. The cost is
+// scheduled once, when the stream is opened.
+func StreamServerInterceptor(lim rate.Limiter, cost time.Duration, keyFunc func(context.Context, string) string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := schedule(lim, cost, keyFunc(ss.Context(), info.FullMethod)); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// schedule schedules the task, returning a ResourceExhausted status error if it is denied
+func schedule(lim rate.Limiter, cost time.Duration, task string) error {
+	delay := lim.Schedule(task, cost)
+	if delay <= 0 {
+		return nil
+	}
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if delay == rate.Forever {
+		return st.Err()
+	}
+	if ds, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = ds
+	}
+	return st.Err()
+}
@@ -0,0 +1,107 @@
+package rategrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/as/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// byMethod returns the full method name as the task
+func byMethod(_ context.Context, method string) string {
+	return method
+}
+
+// retryInfo returns the RetryInfo detail of err, or nil if it has none
+func retryInfo(t *testing.T, err error) *errdetails.RetryInfo {
+	t.Helper()
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		t.Fatalf("bad error: want a ResourceExhausted status, have %v", err)
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			return ri
+		}
+	}
+	return nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	intercept := UnaryServerInterceptor(lim, time.Second, byMethod)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+	called := 0
+	handler := func(ctx context.Context, req any) (any, error) {
+		called++
+		return req, nil
+	}
+
+	if resp, err := intercept(context.Background(), "req", info, handler); err != nil || resp != "req" {
+		t.Fatalf("1/2: have response %v and error %v, want req and no error", resp, err)
+	}
+	_, err := intercept(context.Background(), "req", info, handler)
+	if called != 1 {
+		t.Fatalf("2/2: denied call reached the handler")
+	}
+	ri := retryInfo(t, err)
+	if ri == nil {
+		t.Fatalf("2/2: denied call has no RetryInfo detail")
+	}
+	if d := ri.RetryDelay.AsDuration(); d <= 0 || d > time.Second {
+		t.Fatalf("2/2: bad retry delay: want (0s, 1s], have %s", d)
+	}
+}
+
+func TestUnaryServerInterceptorForever(t *testing.T) {
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	intercept := UnaryServerInterceptor(lim, time.Second*2, byMethod)
+	_, err := intercept(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}, func(context.Context, any) (any, error) {
+		t.Fatalf("oversized call reached the handler")
+		return nil, nil
+	})
+	if ri := retryInfo(t, err); ri != nil {
+		t.Fatalf("call that can never be admitted has a RetryInfo detail: %v", ri)
+	}
+}
+
+// stream is a grpc.ServerStream with a context
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s stream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	intercept := StreamServerInterceptor(lim, time.Second, byMethod)
+	info := &grpc.StreamServerInfo{FullMethod: "/svc/Stream"}
+	ss := stream{ctx: context.Background()}
+	called := 0
+	handler := func(srv any, ss grpc.ServerStream) error {
+		called++
+		return nil
+	}
+
+	if err := intercept(nil, ss, info, handler); err != nil {
+		t.Fatalf("1/2: %v", err)
+	}
+	err := intercept(nil, ss, info, handler)
+	if called != 1 {
+		t.Fatalf("2/2: denied stream reached the handler")
+	}
+	if retryInfo(t, err) == nil {
+		t.Fatalf("2/2: denied stream has no RetryInfo detail")
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/as/rate/ratemetrics
+
+go 1.22
+
+require (
+	github.com/as/rate v0.0.0
+	github.com/prometheus/client_golang v1.20.5
+)
+
+replace github.com/as/rate => ../
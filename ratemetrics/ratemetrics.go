@@ -0,0 +1,58 @@
+// Package ratemetrics exports limiter statistics as Prometheus metrics
+package ratemetrics
+
+import (
+	"github.com/as/rate"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is a limiter that reports statistics, such as the one returned by rate.New
+type Source interface {
+	Stats() rate.Stats
+	Len() int
+}
+
+// Collector is a prometheus.Collector reporting the tracked task count and decision
+// counters of a limiter. Calling ResetStats on the limiter resets the counters.
+type Collector struct {
+	src Source
+
+	tasks, accepted, denied, evicted *prometheus.Desc
+}
+
+// NewCollector returns a Collector for src. The name is reported in the "limiter" label
+// so several limiters can be registered in one process. An empty name is replaced with
+// the limiter's own, the Name reported by its Stats.
+func NewCollector(name string, src Source) *Collector {
+	if name == "" {
+		name = src.Stats().Name
+	}
+	labels := prometheus.Labels{"limiter": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("rate", "", metric), help, nil, labels)
+	}
+	return &Collector{
+		src:      src,
+		tasks:    desc("tasks", "Number of tasks tracked by the limiter."),
+		accepted: desc("accepted_total", "Number of scheduling requests admitted."),
+		denied:   desc("denied_total", "Number of scheduling requests denied."),
+		evicted:  desc("evicted_total", "Number of tasks swept from the limiter."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tasks
+	ch <- c.accepted
+	ch <- c.denied
+	ch <- c.evicted
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(c.tasks, prometheus.GaugeValue, float64(c.src.Len()))
+	ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(s.Accepted))
+	ch <- prometheus.MustNewConstMetric(c.denied, prometheus.CounterValue, float64(s.Denied))
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Evicted))
+}
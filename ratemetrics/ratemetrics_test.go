@@ -0,0 +1,53 @@
+package ratemetrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/rate"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	lim := rate.New(time.Second)
+	defer lim.Close()
+	rate.Allow(lim, "a")
+	rate.Allow(lim, "a")
+	rate.Allow(lim, "b")
+
+	c := NewCollector("api", lim)
+	if n := testutil.CollectAndCount(c); n != 4 {
+		t.Fatalf("bad metric count: want 4, have %d", n)
+	}
+	want := `
+# HELP rate_accepted_total Number of scheduling requests admitted.
+# TYPE rate_accepted_total counter
+rate_accepted_total{limiter="api"} 2
+# HELP rate_denied_total Number of scheduling requests denied.
+# TYPE rate_denied_total counter
+rate_denied_total{limiter="api"} 1
+# HELP rate_evicted_total Number of tasks swept from the limiter.
+# TYPE rate_evicted_total counter
+rate_evicted_total{limiter="api"} 0
+# HELP rate_tasks Number of tasks tracked by the limiter.
+# TYPE rate_tasks gauge
+rate_tasks{limiter="api"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectorName(t *testing.T) {
+	lim := rate.NewWithOptions(time.Second, rate.Options{Name: "global"})
+	defer lim.Close()
+	want := `
+# HELP rate_tasks Number of tasks tracked by the limiter.
+# TYPE rate_tasks gauge
+rate_tasks{limiter="global"} 0
+`
+	if err := testutil.CollectAndCompare(NewCollector("", lim), strings.NewReader(want), "rate_tasks"); err != nil {
+		t.Fatal(err)
+	}
+}
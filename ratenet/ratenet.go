@@ -0,0 +1,70 @@
+// Package ratenet rate-limits network connections
+package ratenet
+
+import (
+	"net"
+	"time"
+
+	"github.com/as/rate"
+)
+
+// Listener is a net.Listener that schedules each accepted connection with its Limiter
+// and Cost, under the task name of the connection's remote IP address.
+type Listener struct {
+	net.Listener
+
+	// Limiter and Cost decide whether a connection is admitted
+	rate.Limiter
+	Cost time.Duration
+
+	// Block, if set, makes Accept wait until a connection is admitted instead of closing
+	// it. Waiting holds the connection open and delays every connection behind it, so
+	// it suits smoothing bursts from well-behaved clients rather than shedding load.
+	Block bool
+}
+
+// LimitListener returns a Listener that closes accepted connections exceeding the limit.
+// Set the returned Listener's Block field to wait for them to be admitted instead.
+func LimitListener(l net.Listener, lim rate.Limiter, cost time.Duration) *Listener {
+	return &Listener{
+		Listener: l,
+		Limiter:  lim,
+		Cost:     cost,
+	}
+}
+
+// Accept returns the next accepted connection that is admitted by the limiter.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		task := remoteIP(c.RemoteAddr())
+		if l.Block {
+			if rate.Wait(l.Limiter, task, l.Cost) == nil {
+				return c, nil
+			}
+		} else if rate.AllowSlice(l.Limiter, task, l.Cost) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
+// Close closes the listener. The limiter is not closed.
+func (l *Listener) Close() error {
+	return l.Listener.Close()
+}
+
+// remoteIP returns the IP address of addr without its port
+func remoteIP(addr net.Addr) string {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,88 @@
+package ratenet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/as/rate/ratetest"
+)
+
+// pipeListener is a net.Listener accepting the server ends of pipes made by dial
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+// dial queues a connection to be accepted and returns its client end
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+	return client
+}
+
+func TestListenerClose(t *testing.T) {
+	pl := &pipeListener{conns: make(chan net.Conn, 2)}
+	lim := ratetest.New(time.Second)
+	lim.QueueDelay(time.Hour)
+	denied, admitted := pl.dial(), pl.dial()
+	defer admitted.Close()
+
+	c, err := LimitListener(pl, lim, time.Second).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go c.Write([]byte("x"))
+	if _, err := admitted.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("the admitted connection was not returned: %v", err)
+	}
+	if _, err := denied.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("the denied connection was not closed: want %v, have %v", io.EOF, err)
+	}
+	if calls := lim.Calls(); len(calls) != 2 || calls[0].Task != "pipe" || calls[0].Slice != time.Second {
+		t.Fatalf("bad calls: %+v", calls)
+	}
+}
+
+func TestListenerBlock(t *testing.T) {
+	pl := &pipeListener{conns: make(chan net.Conn, 1)}
+	lim := ratetest.New(time.Second)
+	lim.QueueDelay(time.Millisecond)
+	client := pl.dial()
+	defer client.Close()
+
+	l := LimitListener(pl, lim, time.Second)
+	l.Block = true
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go c.Write([]byte("x"))
+	if _, err := client.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("the delayed connection was not returned: %v", err)
+	}
+	if calls := lim.Calls(); len(calls) != 2 || calls[0].Delay != time.Millisecond || calls[1].Delay != 0 {
+		t.Fatalf("Accept did not wait for the connection: %+v", calls)
+	}
+}
+
+func TestListenerAcceptError(t *testing.T) {
+	pl := &pipeListener{conns: make(chan net.Conn)}
+	close(pl.conns)
+	if _, err := LimitListener(pl, ratetest.New(time.Second), time.Second).Accept(); err != net.ErrClosed {
+		t.Fatalf("bad error: want %v, have %v", net.ErrClosed, err)
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/as/rate/rateotel
+
+go 1.22
+
+require (
+	go.opentelemetry.io/otel v1.31.0
+	go.opentelemetry.io/otel/metric v1.31.0
+	go.opentelemetry.io/otel/sdk/metric v1.31.0
+)
@@ -0,0 +1,54 @@
+// Package rateotel records the decisions of httprate handlers as OpenTelemetry metrics
+package rateotel
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Recorder records the httprate.requests counter of decisions and the httprate.delay
+// histogram of the delays of denied requests, both with task, route, and decision
+// attributes. The route is the request's http.ServeMux pattern, if any.
+type Recorder struct {
+	requests metric.Int64Counter
+	delays   metric.Float64Histogram
+}
+
+// NewRecorder returns a Recorder creating its instruments from m. Instruments that fail
+// to be created are replaced by the meter's no-op instruments, so errors are ignored.
+// Its Record method is a decision hook for an httprate.Config:
+//
+//	conf := &httprate.Config{OnDecision: rateotel.NewRecorder(meter).Record}
+func NewRecorder(m metric.Meter) *Recorder {
+	requests, _ := m.Int64Counter("httprate.requests",
+		metric.WithDescription("Requests decided by the rate limiter, by decision."),
+		metric.WithUnit("{request}"),
+	)
+	delays, _ := m.Float64Histogram("httprate.delay",
+		metric.WithDescription("Delays returned by the rate limiter for denied requests."),
+		metric.WithUnit("s"),
+	)
+	return &Recorder{requests: requests, delays: delays}
+}
+
+// Record records the decision for the request: admitted if delay is not positive, and
+// denied otherwise.
+func (r *Recorder) Record(rx *http.Request, task string, delay time.Duration) {
+	ctx := rx.Context()
+	decision := "admit"
+	if delay > 0 {
+		decision = "deny"
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("task", task),
+		attribute.String("route", rx.Pattern),
+		attribute.String("decision", decision),
+	)
+	r.requests.Add(ctx, 1, attrs)
+	if delay > 0 {
+		r.delays.Record(ctx, delay.Seconds(), attrs)
+	}
+}
@@ -0,0 +1,75 @@
+package rateotel
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collect returns the metrics recorded through reader by name
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, v := range sm.Metrics {
+			m[v.Name] = v
+		}
+	}
+	return m
+}
+
+// attr returns the value of the attribute key in set
+func attr(set attribute.Set, key string) string {
+	v, _ := set.Value(attribute.Key(key))
+	return v.AsString()
+}
+
+func TestRecorder(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	rec := NewRecorder(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("rateotel"))
+	rx := httptest.NewRequest("GET", "/items/1", nil)
+	rx.Pattern = "GET /items/{id}"
+	rec.Record(rx, "client", 0)
+	rec.Record(rx, "client", -time.Second)
+	rec.Record(rx, "client", time.Second*2)
+
+	m := collect(t, reader)
+	requests, ok := m["httprate.requests"].Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("httprate.requests is not an int64 sum: %T", m["httprate.requests"].Data)
+	}
+	counts := map[string]int64{}
+	for _, dp := range requests.DataPoints {
+		if task, route := attr(dp.Attributes, "task"), attr(dp.Attributes, "route"); task != "client" || route != "GET /items/{id}" {
+			t.Fatalf("bad request attributes: want task client and route GET /items/{id}, have %q and %q", task, route)
+		}
+		counts[attr(dp.Attributes, "decision")] += dp.Value
+	}
+	if counts["admit"] != 2 || counts["deny"] != 1 || len(counts) != 2 {
+		t.Fatalf("bad request counts: want 2 admitted and 1 denied, have %v", counts)
+	}
+
+	delays, ok := m["httprate.delay"].Data.(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("httprate.delay is not a float64 histogram: %T", m["httprate.delay"].Data)
+	}
+	if len(delays.DataPoints) != 1 {
+		t.Fatalf("bad delay data points: want 1, have %d", len(delays.DataPoints))
+	}
+	dp := delays.DataPoints[0]
+	if d := attr(dp.Attributes, "decision"); d != "deny" {
+		t.Fatalf("bad delay decision: want deny, have %q", d)
+	}
+	if dp.Count != 1 || dp.Sum != 2 {
+		t.Fatalf("bad delay histogram: want one delay of 2s, have %d summing to %gs", dp.Count, dp.Sum)
+	}
+}
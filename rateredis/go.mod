@@ -0,0 +1,10 @@
+module github.com/as/rate/rateredis
+
+go 1.22
+
+require (
+	github.com/as/rate v0.0.0
+	github.com/redis/go-redis/v9 v9.7.0
+)
+
+replace github.com/as/rate => ../
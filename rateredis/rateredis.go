@@ -0,0 +1,74 @@
+// Package rateredis provides a rate limiter shared between processes through Redis
+package rateredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/as/rate"
+	"github.com/redis/go-redis/v9"
+)
+
+// schedule applies the floor logic of rate.New to a task's mark atomically. Times are
+// in microseconds and taken from the Redis server, so every client shares one clock.
+// The mark expires once the task's quantum is fully replenished.
+var schedule = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local quantum = tonumber(ARGV[1])
+local mark = tonumber(redis.call('GET', KEYS[1])) or 0
+if mark < now - quantum then
+	mark = now - quantum
+end
+local next = mark + tonumber(ARGV[2])
+local delay = next - now
+if delay <= 0 then
+	redis.call('SET', KEYS[1], string.format('%d', next), 'PX', math.max(1, math.ceil(quantum / 1000)))
+end
+return delay
+`)
+
+// Limiter is a rate.Limiter whose task marks are stored in Redis, so every process
+// using the same keys shares one limit.
+type Limiter struct {
+	client  redis.UniversalClient
+	quantum time.Duration
+	prefix  string
+}
+
+// NewRedis returns a limiter that allows each task to run for the specified quantum,
+// storing the task's mark under keyPrefix+task.
+func NewRedis(client redis.UniversalClient, quantum time.Duration, keyPrefix string) *Limiter {
+	return &Limiter{
+		client:  client,
+		quantum: quantum,
+		prefix:  keyPrefix,
+	}
+}
+
+// Quantum returns the duration allocated for every task.
+func (l *Limiter) Quantum() time.Duration {
+	return l.quantum
+}
+
+// Schedule schedules the task to run for the given time slice if there is quantum
+// available. A slice larger than the quantum is denied with rate.Forever without
+// contacting Redis. If Redis cannot be reached the task is denied with a delay of one
+// quantum. See the rate.Limiter documentation.
+func (l *Limiter) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	if slice > l.quantum {
+		return rate.Forever
+	}
+	us, err := schedule.Run(context.Background(), l.client, []string{l.prefix + task},
+		l.quantum.Microseconds(), slice.Microseconds()).Int64()
+	if err != nil {
+		return l.quantum
+	}
+	return time.Duration(us) * time.Microsecond
+}
+
+// Close does nothing. The client is shared and remains open.
+func (l *Limiter) Close() error {
+	return nil
+}
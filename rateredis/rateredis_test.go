@@ -0,0 +1,74 @@
+package rateredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/as/rate"
+	"github.com/redis/go-redis/v9"
+)
+
+// server returns a client for the Redis server at $RATEREDIS_ADDR, skipping the test
+// if it is not set
+func server(t *testing.T) redis.UniversalClient {
+	addr := os.Getenv("RATEREDIS_ADDR")
+	if addr == "" {
+		t.Skip("RATEREDIS_ADDR is not set")
+	}
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// prefix returns a key prefix unique to the test run
+func prefix(t *testing.T) string {
+	return fmt.Sprintf("rateredis:%s:%d:", t.Name(), time.Now().UnixNano())
+}
+
+func TestSchedule(t *testing.T) {
+	c := server(t)
+	l := NewRedis(c, time.Minute, prefix(t))
+	defer l.Close()
+	if delay := l.Schedule("a", time.Second*30); delay > 0 {
+		t.Fatalf("1/3: have delay %s, want admission", delay)
+	}
+	if delay := l.Schedule("a", time.Second*30); delay > 0 {
+		t.Fatalf("2/3: have delay %s, want admission", delay)
+	}
+	if delay := l.Schedule("a", time.Second*30); delay <= 0 || delay > time.Second*30 {
+		t.Fatalf("3/3: bad delay: want (0s, 30s], have %s", delay)
+	}
+	if delay := l.Schedule("b", time.Second); delay > 0 {
+		t.Fatalf("other task: have delay %s, want admission", delay)
+	}
+}
+
+func TestScheduleShared(t *testing.T) {
+	c := server(t)
+	p := prefix(t)
+	a, b := NewRedis(c, time.Minute, p), NewRedis(c, time.Minute, p)
+	if delay := a.Schedule("a", time.Minute); delay > 0 {
+		t.Fatalf("have delay %s, want admission", delay)
+	}
+	if delay := b.Schedule("a", time.Second); delay <= 0 {
+		t.Fatalf("limiter sharing the prefix admitted an exhausted task")
+	}
+}
+
+func TestScheduleExceedsQuantum(t *testing.T) {
+	l := NewRedis(nil, time.Second, "")
+	if delay := l.Schedule("a", time.Second*2); delay != rate.Forever {
+		t.Fatalf("bad delay: want Forever, have %s", delay)
+	}
+}
+
+func TestScheduleUnreachable(t *testing.T) {
+	c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: time.Second})
+	defer c.Close()
+	l := NewRedis(c, time.Second*5, "")
+	if delay := l.Schedule("a", time.Second); delay != time.Second*5 {
+		t.Fatalf("bad delay: want the quantum, 5s, have %s", delay)
+	}
+}
@@ -0,0 +1,80 @@
+// Package ratetest provides a scriptable rate.Limiter for testing code that uses one
+package ratetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/as/rate"
+)
+
+// Call is a single call to Limiter.Schedule.
+type Call struct {
+	Task  string
+	Slice time.Duration
+	Delay time.Duration
+}
+
+// Limiter is a rate.Limiter that records every Schedule call and returns the delays
+// queued with QueueDelay, in order. Once the queue is empty, every task is admitted.
+// It is safe for concurrent use.
+type Limiter struct {
+	quantum time.Duration
+
+	mu     sync.Mutex
+	queue  []time.Duration
+	calls  []Call
+	closed bool
+}
+
+// New returns a Limiter reporting the given quantum.
+func New(quantum time.Duration) *Limiter {
+	return &Limiter{quantum: quantum}
+}
+
+// QueueDelay queues delays to be returned by the next calls to Schedule.
+func (l *Limiter) QueueDelay(delays ...time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queue = append(l.queue, delays...)
+}
+
+// Quantum returns the quantum passed to New.
+func (l *Limiter) Quantum() time.Duration {
+	return l.quantum
+}
+
+// Schedule records the call and returns the next queued delay, or zero if there is none.
+func (l *Limiter) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.queue) > 0 {
+		delay, l.queue = l.queue[0], l.queue[1:]
+	}
+	l.calls = append(l.calls, Call{Task: task, Slice: slice, Delay: delay})
+	return delay
+}
+
+// Calls returns a copy of the calls to Schedule made so far.
+func (l *Limiter) Calls() []Call {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Call(nil), l.calls...)
+}
+
+// Closed returns true if Close was called.
+func (l *Limiter) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// Close records that the limiter was closed.
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return nil
+}
+
+var _ rate.Limiter = (*Limiter)(nil)
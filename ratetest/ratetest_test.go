@@ -0,0 +1,36 @@
+package ratetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/as/rate"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(time.Second * 2)
+	if l.Quantum() != time.Second*2 {
+		t.Fatalf("wrong quantum: want 2s, have %s", l.Quantum())
+	}
+	l.QueueDelay(time.Second, rate.Forever)
+	for i, want := range []time.Duration{time.Second, rate.Forever, 0, 0} {
+		if delay := l.Schedule("a", time.Millisecond*time.Duration(i)); delay != want {
+			t.Fatalf("%d: bad delay: want %s, have %s", i, want, delay)
+		}
+	}
+	calls := l.Calls()
+	if len(calls) != 4 || calls[1] != (Call{Task: "a", Slice: time.Millisecond, Delay: rate.Forever}) {
+		t.Fatalf("bad calls: %+v", calls)
+	}
+	calls[0].Task = "b"
+	if l.Calls()[0].Task != "a" {
+		t.Fatalf("Calls did not return a copy")
+	}
+	if l.Closed() {
+		t.Fatalf("closed before Close")
+	}
+	l.Close()
+	if !l.Closed() {
+		t.Fatalf("not closed after Close")
+	}
+}
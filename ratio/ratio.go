@@ -0,0 +1,95 @@
+// Package ratio paces data transfer through a limiter
+package ratio
+
+import (
+	"io"
+	"time"
+
+	"github.com/as/rate"
+)
+
+// Reader returns a reader that charges the task perByte of quantum for every byte read
+// from r, sleeping until the limiter admits each read. Since the limiter replenishes one
+// second of quantum per second, the task reads at most one byte per perByte on average.
+// Reads are shortened to fit in the limiter's quantum. Errors from r are returned
+// unchanged, along with the bytes read before them. If a single byte costs more than the
+// quantum, every read fails with rate.ErrExceedsQuantum without reading from r.
+func Reader(r io.Reader, lim rate.Limiter, task string, perByte time.Duration) io.Reader {
+	return &reader{r: r, pacer: pacer{lim: lim, task: task, perByte: perByte}}
+}
+
+// Writer returns a writer that charges the task perByte of quantum for every byte
+// written to w, sleeping until the limiter admits each write. Like Reader, writes are
+// split to fit in the limiter's quantum, and fail with rate.ErrExceedsQuantum without
+// writing to w if a single byte costs more than the quantum.
+func Writer(w io.Writer, lim rate.Limiter, task string, perByte time.Duration) io.Writer {
+	return &writer{w: w, pacer: pacer{lim: lim, task: task, perByte: perByte}}
+}
+
+// pacer charges bytes to a task
+type pacer struct {
+	lim     rate.Limiter
+	task    string
+	perByte time.Duration
+}
+
+// clip shortens b to the most bytes the limiter can admit at once. It returns
+// rate.ErrExceedsQuantum if that is none of them.
+func (p pacer) clip(b []byte) ([]byte, error) {
+	if p.perByte <= 0 || len(b) == 0 {
+		return b, nil
+	}
+	n := int(min(p.lim.Quantum()/p.perByte, time.Duration(len(b))))
+	if n == 0 {
+		return nil, rate.ErrExceedsQuantum
+	}
+	return b[:n], nil
+}
+
+// charge waits until the limiter admits n bytes
+func (p pacer) charge(n int) error {
+	return rate.Wait(p.lim, p.task, time.Duration(n)*p.perByte)
+}
+
+type reader struct {
+	r io.Reader
+	pacer
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	p, err := r.clip(p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if err := r.charge(n); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	w io.Writer
+	pacer
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk, err := w.clip(p)
+		if err != nil {
+			return n, err
+		}
+		if err := w.charge(len(chunk)); err != nil {
+			return n, err
+		}
+		m, err := w.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
@@ -0,0 +1,119 @@
+package ratio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/as/rate"
+	"github.com/as/rate/ratetest"
+)
+
+// slices returns the slices scheduled with lim
+func slices(lim *ratetest.Limiter) (s []time.Duration) {
+	for _, c := range lim.Calls() {
+		s = append(s, c.Slice)
+	}
+	return s
+}
+
+func TestReader(t *testing.T) {
+	lim := ratetest.New(time.Second * 4)
+	r := Reader(strings.NewReader("hello world"), lim, "a", time.Second)
+	p := make([]byte, 10)
+	n, err := r.Read(p)
+	if n != 4 || err != nil || string(p[:n]) != "hell" {
+		t.Fatalf("1/2: read not clipped to the quantum: have %d %v %q", n, err, p[:n])
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || string(b) != "o world" {
+		t.Fatalf("2/2: bad read: have %q %v", b, err)
+	}
+	if s := slices(lim); len(s) != 3 || s[0] != time.Second*4 || s[1] != time.Second*4 || s[2] != time.Second*3 {
+		t.Fatalf("bad charges: %v", s)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	errRead := errors.New("read failed")
+	lim := ratetest.New(time.Second * 4)
+	r := Reader(io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(errRead)), lim, "a", time.Second)
+	if b, err := io.ReadAll(r); string(b) != "ab" || err != errRead {
+		t.Fatalf("reader error: have %q %v, want %q %v", b, err, "ab", errRead)
+	}
+
+	lim = ratetest.New(time.Second * 4)
+	lim.QueueDelay(rate.Forever)
+	p := make([]byte, 4)
+	if n, err := Reader(strings.NewReader("ab"), lim, "a", time.Second).Read(p); n != 2 || err != rate.ErrClosed {
+		t.Fatalf("limiter error: have %d %v, want 2 %v", n, err, rate.ErrClosed)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	lim := ratetest.New(time.Second * 4)
+	var buf bytes.Buffer
+	n, err := Writer(&buf, lim, "a", time.Second).Write([]byte("hello world"))
+	if n != 11 || err != nil || buf.String() != "hello world" {
+		t.Fatalf("bad write: have %d %v %q", n, err, buf.String())
+	}
+	if s := slices(lim); len(s) != 3 || s[0] != time.Second*4 || s[1] != time.Second*4 || s[2] != time.Second*3 {
+		t.Fatalf("write not split to the quantum: %v", s)
+	}
+}
+
+// failWriter accepts up to n bytes and then fails
+type failWriter struct {
+	n   int
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriterErrors(t *testing.T) {
+	errWrite := errors.New("write failed")
+	lim := ratetest.New(time.Second * 4)
+	w := Writer(&failWriter{n: 6, err: errWrite}, lim, "a", time.Second)
+	if n, err := w.Write([]byte("hello world")); n != 6 || err != errWrite {
+		t.Fatalf("writer error: have %d %v, want 6 %v", n, err, errWrite)
+	}
+
+	lim = ratetest.New(time.Second * 4)
+	lim.QueueDelay(0, rate.Forever)
+	var buf bytes.Buffer
+	if n, err := Writer(&buf, lim, "a", time.Second).Write([]byte("hello world")); n != 4 || err != rate.ErrClosed || buf.String() != "hell" {
+		t.Fatalf("limiter error: have %d %v %q, want 4 %v", n, err, buf.String(), rate.ErrClosed)
+	}
+}
+
+func TestExceedsQuantum(t *testing.T) {
+	lim := ratetest.New(time.Second)
+	src := strings.NewReader("hello")
+	for i := 0; i < 2; i++ {
+		if n, err := Reader(src, lim, "a", time.Second*2).Read(make([]byte, 4)); n != 0 || err != rate.ErrExceedsQuantum {
+			t.Fatalf("read: have %d %v, want 0 %v", n, err, rate.ErrExceedsQuantum)
+		}
+	}
+	if src.Len() != 5 {
+		t.Fatalf("read consumed %d bytes from the reader", 5-src.Len())
+	}
+	var buf bytes.Buffer
+	if n, err := Writer(&buf, lim, "a", time.Second*2).Write([]byte("hello")); n != 0 || err != rate.ErrExceedsQuantum || buf.Len() != 0 {
+		t.Fatalf("write: have %d %v %q, want 0 %v", n, err, buf.String(), rate.ErrExceedsQuantum)
+	}
+	if n := len(lim.Calls()); n != 0 {
+		t.Fatalf("limiter was scheduled %d times", n)
+	}
+}
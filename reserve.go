@@ -0,0 +1,57 @@
+package rate
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Reservation records the quantum consumed by Reserve so it can be given back.
+type Reservation = KeyedReservation[string]
+
+// KeyedReservation is a Reservation for a task identified by a key of type K.
+type KeyedReservation[K comparable] struct {
+	l        *keyed[K]
+	task     K
+	consumed time.Duration // the weighed slice
+	delay    time.Duration
+	canceled atomic.Bool
+}
+
+// Reserve schedules the task like Schedule, returning a Reservation that can give
+// the slice back with Cancel if the work ends up not happening.
+func (l *keyed[K]) Reserve(task K, slice time.Duration) *KeyedReservation[K] {
+	r := &KeyedReservation[K]{l: l, task: task, delay: Forever}
+	l.do(func() {
+		r.consumed = l.weigh(task, slice)
+		r.delay = l.decide(task, slice, l.now())
+	})
+	return r
+}
+
+// OK returns true if the slice was admitted and consumed.
+func (r *KeyedReservation[K]) OK() bool {
+	return r.delay <= 0
+}
+
+// Delay returns the delay returned when the reservation was made.
+func (r *KeyedReservation[K]) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel gives the reserved slice back to the task by moving its mark back by the quantum
+// it consumed, which is the slice scaled by the task's weight at the time. Quantum that replenished since the reservation is kept, so the task ends up as though
+// the reservation was never made. Cancel does nothing if the reservation was not admitted,
+// was already canceled, or the task's quantum has since fully replenished.
+func (r *KeyedReservation[K]) Cancel() {
+	if !r.OK() || !r.canceled.CompareAndSwap(false, true) {
+		return
+	}
+	l := r.l
+	l.do(func() {
+		mark, ok := l.marks[r.task]
+		if !ok || l.stale(mark, l.now()) {
+			return
+		}
+		l.marks[r.task] = mark.Add(-r.consumed)
+	})
+}
@@ -0,0 +1,65 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReservation(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*3, clock)
+	defer l.Close()
+	AllowSlice(l, "a", time.Second)
+	r := l.Reserve("a", time.Second*2)
+	if !r.OK() || r.Delay() > 0 {
+		t.Fatalf("reservation denied: delay %s", r.Delay())
+	}
+	if Allow(l, "a") {
+		t.Fatalf("reservation did not consume quantum")
+	}
+	clock.Advance(time.Second)
+	r.Cancel()
+	r.Cancel()
+	if rem := l.Remaining("a"); rem != time.Second*3 {
+		t.Fatalf("bad remaining after cancel: want 3s, have %s", rem)
+	}
+
+	if r := l.Reserve("a", time.Second*4); r.OK() {
+		t.Fatalf("oversized reservation admitted")
+	} else {
+		r.Cancel()
+	}
+}
+
+func TestReservationReplenished(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*2, clock)
+	defer l.Close()
+	r := l.Reserve("a", time.Second*2)
+	clock.Advance(time.Second * 5)
+	r.Cancel()
+	if rem := l.Remaining("a"); rem != time.Second*2 {
+		t.Fatalf("bad remaining: want 2s, have %s", rem)
+	}
+	if !AllowSlice(l, "a", time.Second*2) || Allow(l, "a") {
+		t.Fatalf("cancel after replenishment granted extra quantum")
+	}
+}
+
+func TestReservationWeight(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*8, clock)
+	defer l.Close()
+	l.SetWeight("a", 4)
+	r := l.Reserve("a", time.Second)
+	if !r.OK() {
+		t.Fatalf("reservation denied: delay %s", r.Delay())
+	}
+	if rem := l.Remaining("a"); rem != time.Second*4 {
+		t.Fatalf("bad remaining after reserve: want 4s, have %s", rem)
+	}
+	r.Cancel()
+	if rem := l.Remaining("a"); rem != time.Second*8 {
+		t.Fatalf("bad remaining after cancel: want 8s, have %s", rem)
+	}
+}
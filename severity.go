@@ -0,0 +1,47 @@
+package rate
+
+import (
+	"time"
+)
+
+// Level grades a delay returned by Schedule by how long a caller would have to wait.
+type Level int
+
+const (
+	// Low is a delay of less than a quarter of the quantum, including no delay at all:
+	// the task can run soon, so it is worth queueing or retrying
+	Low Level = iota
+
+	// Medium is a delay of at least a quarter of the quantum, but less than the whole
+	// quantum
+	Medium
+
+	// High is a delay of at least the quantum, including Forever: the task is out of
+	// quantum for the foreseeable future, so it should be dropped, as with an HTTP 503
+	// instead of a 429
+	High
+)
+
+// String returns the level's name.
+func (v Level) String() string {
+	switch v {
+	case Low:
+		return "low"
+	case Medium:
+		return "medium"
+	case High:
+		return "high"
+	}
+	return "unknown"
+}
+
+// Severity returns the Level of a delay returned by a limiter with the given quantum.
+func Severity(delay, quantum time.Duration) Level {
+	switch {
+	case delay >= quantum:
+		return High
+	case delay >= quantum/4:
+		return Medium
+	}
+	return Low
+}
@@ -0,0 +1,26 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeverity(t *testing.T) {
+	q := time.Second * 4
+	for _, tc := range []struct {
+		delay time.Duration
+		want  Level
+	}{
+		{-time.Second, Low},
+		{0, Low},
+		{time.Second - 1, Low},
+		{time.Second, Medium},
+		{q - 1, Medium},
+		{q, High},
+		{Forever, High},
+	} {
+		if have := Severity(tc.delay, q); have != tc.want {
+			t.Errorf("Severity(%s, %s): want %s, have %s", tc.delay, q, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package rate
+
+import (
+	"time"
+)
+
+// NewSharded returns a limiter that spreads tasks across the given number of independent
+// limiters, each with its own goroutine and map. A task always hashes to the same shard,
+// so per-task behavior matches New while distinct tasks no longer contend on a single
+// goroutine.
+func NewSharded(quantum time.Duration, shards int) sharded {
+	if shards < 1 {
+		shards = 1
+	}
+	s := make(sharded, shards)
+	for i := range s {
+		s[i] = New(quantum)
+	}
+	return s
+}
+
+// sharded is a limiter partitioned by task name
+type sharded []*limiter
+
+// Quantum returns the quantum shared by every shard.
+func (s sharded) Quantum() time.Duration {
+	return s[0].Quantum()
+}
+
+// Schedule schedules the task on its shard. See interface documentation.
+func (s sharded) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	return s.shard(task).Schedule(task, slice)
+}
+
+// Close closes every shard.
+func (s sharded) Close() error {
+	for _, l := range s {
+		l.Close()
+	}
+	return nil
+}
+
+// shard returns the limiter responsible for task
+func (s sharded) shard(task string) *limiter {
+	return s[hash(task)%uint32(len(s))]
+}
+
+// hash returns the 32-bit FNV-1a hash of the task
+func hash(task string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(task); i++ {
+		h ^= uint32(task[i])
+		h *= 16777619
+	}
+	return h
+}
@@ -0,0 +1,27 @@
+package rate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSharded(t *testing.T) {
+	l := NewSharded(time.Second*30, 4)
+	defer l.Close()
+	if l.Quantum() != time.Second*30 {
+		t.Fatalf("wrong quantum: want 30s, have %s", l.Quantum())
+	}
+	for i := 0; i < 8; i++ {
+		task := fmt.Sprint(i)
+		n := 0
+		for ; n < 100; n++ {
+			if !Allow(l, task) {
+				break
+			}
+		}
+		if n != 30 {
+			t.Fatalf("task %s: bad request count: want 30, have %d", task, n)
+		}
+	}
+}
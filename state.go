@@ -0,0 +1,95 @@
+package rate
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// State is a serializable copy of a limiter's quantum and task marks. Its JSON form
+// records the quantum as a duration string and each mark as an RFC 3339 timestamp:
+//
+//	{"quantum":"30s","marks":{"example.com":"2009-11-10T23:00:00.5Z"}}
+type State = KeyedState[string]
+
+// KeyedState is the State of a limiter for tasks identified by keys of type K. Only
+// keys that encoding/json supports as map keys can be marshaled.
+type KeyedState[K comparable] struct {
+	Quantum time.Duration
+	Marks   map[K]time.Time
+}
+
+// State returns the limiter's current state.
+func (l *keyed[K]) State() KeyedState[K] {
+	return KeyedState[K]{
+		Quantum: l.Quantum(),
+		Marks:   l.Snapshot(),
+	}
+}
+
+// RestoreState seeds the limiter with the marks in s. The limiter's own quantum wins
+// over s.Quantum, so a limiter restored with a different quantum floors the restored
+// marks against its own.
+func (l *keyed[K]) RestoreState(s KeyedState[K]) {
+	l.Restore(s.Marks)
+}
+
+// Save writes the limiter's state to w as JSON.
+func (l *keyed[K]) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(l.State())
+}
+
+// Load reads a state written by Save from r and restores its marks. Marks older than
+// the limiter's quantum are dropped rather than loaded.
+func (l *keyed[K]) Load(r io.Reader) error {
+	var s KeyedState[K]
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	l.do(func() {
+		now := l.now()
+		for k, v := range s.Marks {
+			if !l.stale(v, now) {
+				l.set(k, v)
+			}
+		}
+	})
+	return nil
+}
+
+type stateJSON[K comparable] struct {
+	Quantum string       `json:"quantum"`
+	Marks   map[K]string `json:"marks"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (s KeyedState[K]) MarshalJSON() ([]byte, error) {
+	v := stateJSON[K]{
+		Quantum: s.Quantum.String(),
+		Marks:   make(map[K]string, len(s.Marks)),
+	}
+	for k, t := range s.Marks {
+		v.Marks[k] = t.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *KeyedState[K]) UnmarshalJSON(data []byte) error {
+	var v stateJSON[K]
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	q, err := time.ParseDuration(v.Quantum)
+	if err != nil {
+		return err
+	}
+	marks := make(map[K]time.Time, len(v.Marks))
+	for k, ts := range v.Marks {
+		if marks[k], err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return err
+		}
+	}
+	s.Quantum, s.Marks = q, marks
+	return nil
+}
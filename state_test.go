@@ -0,0 +1,78 @@
+package rate
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStateJSON(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*2, clock)
+	defer l.Close()
+	AllowSlice(l, "a", time.Second*2)
+	Allow(l, "b")
+
+	data, err := json.Marshal(l.State())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if s.Quantum != time.Second*2 || len(s.Marks) != 2 {
+		t.Fatalf("bad state: %s", data)
+	}
+
+	l2 := NewWithClock(time.Second*3, clock)
+	defer l2.Close()
+	l2.RestoreState(s)
+	if l2.Quantum() != time.Second*3 {
+		t.Fatalf("restored quantum should not win: have %s", l2.Quantum())
+	}
+	if Allow(l2, "a") {
+		t.Fatalf("restored task a: have allow, want deny")
+	}
+}
+
+func TestStateJSONInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"quantum":"x","marks":{}}`,
+		`{"quantum":"1s","marks":{"a":"yesterday"}}`,
+	} {
+		var s State
+		if err := json.Unmarshal([]byte(data), &s); err == nil {
+			t.Fatalf("%s: want error, have nil", data)
+		}
+	}
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*2, clock)
+	defer l.Close()
+	AllowSlice(l, "old", time.Second)
+	clock.Advance(time.Second * 2)
+	AllowSlice(l, "new", time.Second*2)
+
+	var buf bytes.Buffer
+	if err := l.Save(&buf); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	l2 := NewWithClock(time.Second*2, clock)
+	defer l2.Close()
+	if err := l2.Load(&buf); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if tasks := l2.Tasks(); len(tasks) != 1 || tasks[0] != "new" {
+		t.Fatalf("bad tasks after load: want [new], have %v", tasks)
+	}
+	if Allow(l2, "new") {
+		t.Fatalf("loaded task: have allow, want deny")
+	}
+	if err := l2.Load(bytes.NewReader([]byte("{"))); err == nil {
+		t.Fatalf("load of truncated state: want error, have nil")
+	}
+}
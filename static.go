@@ -0,0 +1,44 @@
+package rate
+
+import (
+	"time"
+)
+
+// Unlimited returns a Limiter that admits every task, for disabling rate limiting in
+// tests and development without changing call sites. Its quantum is Forever.
+func Unlimited() Limiter {
+	return unlimited{}
+}
+
+// unlimited is a limiter that admits everything
+type unlimited struct{}
+
+// Quantum returns Forever.
+func (unlimited) Quantum() time.Duration { return Forever }
+
+// Schedule admits the task. See interface documentation.
+func (unlimited) Schedule(task string, slice time.Duration) (delay time.Duration) { return 0 }
+
+// Close does nothing.
+func (unlimited) Close() error { return nil }
+
+// Denied returns a Limiter that denies every task with the given retry delay, for
+// rejecting all work during maintenance. Its quantum is zero, so Wait fails at once
+// with ErrExceedsQuantum instead of waiting forever. The delay must be positive.
+func Denied(retry time.Duration) Limiter {
+	return denied(retry)
+}
+
+// denied is a limiter that denies everything with a fixed delay
+type denied time.Duration
+
+// Quantum returns zero.
+func (denied) Quantum() time.Duration { return 0 }
+
+// Schedule denies the task. See interface documentation.
+func (d denied) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	return time.Duration(d)
+}
+
+// Close does nothing.
+func (denied) Close() error { return nil }
@@ -0,0 +1,30 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnlimited(t *testing.T) {
+	l := Unlimited()
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		if !AllowSlice(l, "a", time.Hour) {
+			t.Fatalf("%d: have deny, want allow", i)
+		}
+	}
+	if err := Wait(l, "a", time.Hour*24); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+}
+
+func TestDenied(t *testing.T) {
+	l := Denied(time.Minute)
+	defer l.Close()
+	if delay := l.Schedule("a", 0); delay != time.Minute {
+		t.Fatalf("bad delay: want 1m, have %s", delay)
+	}
+	if err := Wait(l, "a", time.Second); err != ErrExceedsQuantum {
+		t.Fatalf("bad wait error: want %v, have %v", ErrExceedsQuantum, err)
+	}
+}
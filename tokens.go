@@ -0,0 +1,42 @@
+package rate
+
+import (
+	"errors"
+	"time"
+)
+
+// NewTokens returns a limiter that admits up to limit tokens per task in each interval
+// of length per, for limits naturally stated as "N requests per interval". It is a
+// limiter returned by New with a quantum of per, on which each token is a slice of
+// per/limit, so AllowTokens(task, n) behaves exactly like AllowSlice(l, task, n×per/limit).
+// It panics if limit or per is not positive.
+func NewTokens(limit int, per time.Duration) *counted {
+	if limit <= 0 {
+		panic(errors.New("rate: token limit must be positive"))
+	}
+	return &counted{
+		limiter: New(per),
+		token:   per / time.Duration(limit),
+	}
+}
+
+// counted is a limiter scheduling tasks in tokens
+type counted struct {
+	*limiter
+	token time.Duration
+}
+
+// Token returns the slice scheduled for each token.
+func (c *counted) Token() time.Duration {
+	return c.token
+}
+
+// AllowToken returns true if the task may spend a single token.
+func (c *counted) AllowToken(task string) bool {
+	return c.AllowTokens(task, 1)
+}
+
+// AllowTokens returns true if the task may spend n tokens, consuming them if so.
+func (c *counted) AllowTokens(task string, n int) bool {
+	return c.Schedule(task, c.token*time.Duration(n)) <= 0
+}
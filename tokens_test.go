@@ -0,0 +1,26 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	l := NewTokens(3, time.Minute)
+	defer l.Close()
+	if l.Token() != time.Second*20 {
+		t.Fatalf("bad token: want 20s, have %s", l.Token())
+	}
+	if !l.AllowToken("a") || !l.AllowTokens("a", 2) {
+		t.Fatalf("1/2: have deny, want allow")
+	}
+	if l.AllowToken("a") || l.AllowTokens("b", 4) {
+		t.Fatalf("2/2: have allow, want deny")
+	}
+
+	l2 := NewTokens(3, time.Second)
+	defer l2.Close()
+	if !l2.AllowTokens("a", 3) {
+		t.Fatalf("inexact token: have deny, want allow")
+	}
+}
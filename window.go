@@ -0,0 +1,75 @@
+package rate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// NewFixedWindow returns a limiter that admits up to limit units per task in each
+// fixed window of wall-clock time, such as each calendar minute. Scheduling a slice
+// counts one unit per second of the slice; Allow counts a single unit.
+//
+// Unlike the limiter returned by New, quantum is not replenished gradually: every
+// task's count resets at once on each window boundary. A task that reaches its limit
+// is given the delay until the next boundary. It panics if limit or window is not
+// positive.
+func NewFixedWindow(limit int, window time.Duration) *fixedWindow {
+	if limit <= 0 {
+		panic(errors.New("rate: window limit must be positive"))
+	}
+	if window <= 0 {
+		panic(errors.New("rate: window must be positive"))
+	}
+	return &fixedWindow{
+		limit:  float64(limit),
+		window: window,
+		clock:  realClock{},
+		counts: make(map[string]float64, preallocEntries),
+	}
+}
+
+// fixedWindow is a fixed-window rate limiter
+type fixedWindow struct {
+	limit  float64
+	window time.Duration
+	clock  Clock
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]float64
+}
+
+// Quantum returns the duration of the largest slice admissible in one window.
+func (w *fixedWindow) Quantum() time.Duration {
+	return time.Duration(w.limit * float64(time.Second))
+}
+
+// Schedule counts the slice against the task's limit for the current window if it
+// fits. Otherwise it returns the delay until the next window, or Forever if the slice
+// exceeds the limit of any window. See interface documentation.
+func (w *fixedWindow) Schedule(task string, slice time.Duration) (delay time.Duration) {
+	if slice > w.Quantum() {
+		return Forever
+	}
+	now := w.clock.Now()
+	start := now.Truncate(w.window)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !start.Equal(w.start) {
+		w.start = start
+		w.counts = make(map[string]float64, preallocEntries)
+	}
+	n := w.counts[task] + slice.Seconds()
+	if n > w.limit {
+		return start.Add(w.window).Sub(now)
+	}
+	w.counts[task] = n
+	return 0
+}
+
+// Close releases the limiter's resources.
+func (w *fixedWindow) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package rate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedWindow(t *testing.T) {
+	clock := newFakeClock()
+	w := NewFixedWindow(3, time.Minute)
+	w.clock = clock
+	defer w.Close()
+	clock.now = clock.now.Truncate(time.Minute).Add(time.Second * 50)
+	for _, task := range []string{"a", "b"} {
+		n := 0
+		for ; n < 100; n++ {
+			if !Allow(w, task) {
+				break
+			}
+		}
+		if n != 3 {
+			t.Fatalf("task %s: bad request count: want 3, have %d", task, n)
+		}
+	}
+	if delay := w.Schedule("a", time.Second); delay != time.Second*10 {
+		t.Fatalf("bad delay: want 10s, have %s", delay)
+	}
+	clock.Advance(time.Second * 10)
+	if !AllowSlice(w, "a", time.Second*3) || !AllowSlice(w, "b", time.Second*3) {
+		t.Fatalf("counts did not reset on the window boundary")
+	}
+}
+
+func TestFixedWindowInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		limit  int
+		window time.Duration
+	}{
+		{0, time.Minute}, {-1, time.Minute}, {1, 0}, {1, -time.Minute},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("limit %d, window %s: NewFixedWindow did not panic", tc.limit, tc.window)
+				}
+			}()
+			NewFixedWindow(tc.limit, tc.window)
+		}()
+	}
+}
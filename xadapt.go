@@ -0,0 +1,41 @@
+package rate
+
+import (
+	"context"
+	"time"
+)
+
+// XAdapt returns a single-resource view of l bound to task, with the Allow, AllowN,
+// and Wait methods of golang.org/x/time/rate's Limiter. Each event costs one second
+// of the task's quantum, as with the package-level Allow.
+//
+// AllowN evaluates the task at the given time only if l supports ScheduleAt, as the
+// limiter returned by New does; other limiters evaluate it at time.Now().
+func XAdapt(l Limiter, task string) *xlimiter {
+	return &xlimiter{l: l, task: task}
+}
+
+// xlimiter is a Limiter bound to a single task
+type xlimiter struct {
+	l    Limiter
+	task string
+}
+
+// Allow reports whether one event may happen now.
+func (x *xlimiter) Allow() bool {
+	return Allow(x.l, x.task)
+}
+
+// AllowN reports whether n events may happen at time t.
+func (x *xlimiter) AllowN(t time.Time, n int) bool {
+	slice := time.Duration(n) * time.Second
+	if l, ok := x.l.(TimedLimiter); ok {
+		return AllowSliceAt(l, x.task, slice, t)
+	}
+	return x.l.Schedule(x.task, slice) <= 0
+}
+
+// Wait blocks until one event is allowed or ctx is done.
+func (x *xlimiter) Wait(ctx context.Context) error {
+	return wait(ctx, x.l, x.task, time.Second)
+}
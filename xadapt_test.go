@@ -0,0 +1,29 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestXAdapt(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(time.Second*3, clock)
+	defer l.Close()
+	x := XAdapt(l, "a")
+	if !x.Allow() || !x.AllowN(clock.Now(), 2) {
+		t.Fatalf("1/3: have deny, want allow")
+	}
+	if x.Allow() || Allow(l, "a") {
+		t.Fatalf("1/3: adapter not bound to task a")
+	}
+	if !x.AllowN(clock.Now().Add(time.Second), 1) {
+		t.Fatalf("2/3: AllowN ignored its time: have deny, want allow")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := x.Wait(ctx); err != context.Canceled {
+		t.Fatalf("3/3: bad wait error: want %v, have %v", context.Canceled, err)
+	}
+}